@@ -0,0 +1,65 @@
+package main
+
+import (
+	"RP-UCLA/backend-reader/internal/export"
+	"RP-UCLA/backend-reader/internal/processing"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+const (
+	EXPORT_FORMAT_CHROME = "chrome"
+	EXPORT_FORMAT_FOLDED = "folded"
+)
+
+// runExport handles `backend-reader export [-format chrome|folded] [-o out.json] session.hrec`
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", EXPORT_FORMAT_CHROME, "export format, chrome writes Chrome Trace Event JSON for Perfetto / chrome://tracing, folded writes folded stacks for flamegraph.pl / speedscope")
+	weight := fs.String("weight", processing.FOLDED_WEIGHT_SELF, "weight folded stacks by self or total microseconds, only self is valid flame graph input")
+	outputPath := fs.String("o", "", "file to write to, defaults to stdout")
+	cores := fs.Int("cores", processing.DEFAULT_CORE_COUNT, "number of cores on the chips the session was recorded from")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s export [flags] session.hrec\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	sessionPath := fs.Arg(0)
+
+	if *cores <= 0 {
+		log.Fatalf("Core count must be positive, got %d\n", *cores)
+	}
+
+	var output io.Writer = os.Stdout
+	if *outputPath != "" {
+		file, err := os.Create(*outputPath)
+		if err != nil {
+			log.Fatalf("Unable to create %s: %v\n", *outputPath, err)
+		}
+		defer file.Close()
+
+		output = file
+	}
+
+	var err error
+	switch *format {
+	case EXPORT_FORMAT_CHROME:
+		err = export.ExportChromeTrace(sessionPath, uint32(*cores), output)
+	case EXPORT_FORMAT_FOLDED:
+		err = export.ExportFoldedStacks(sessionPath, uint32(*cores), output, processing.FoldedStackFilter{Weight: *weight})
+	default:
+		log.Fatalf("Unknown export format %q\n", *format)
+	}
+
+	if err != nil {
+		log.Fatalf("Unable to export %s: %v\n", sessionPath, err)
+	}
+}
@@ -1,31 +1,126 @@
 package main
 
 import (
+	"RP-UCLA/backend-reader/internal/alerting"
+	"RP-UCLA/backend-reader/internal/config"
+	"RP-UCLA/backend-reader/internal/export"
 	"RP-UCLA/backend-reader/internal/processing"
+	"RP-UCLA/backend-reader/internal/recording"
+	tracereader "RP-UCLA/backend-reader/internal/traceReader"
+	"RP-UCLA/backend-reader/internal/traceReader/rSerial"
+	replayreader "RP-UCLA/backend-reader/internal/traceReader/replayReader"
 	udpreader "RP-UCLA/backend-reader/internal/traceReader/udpReader"
+	"RP-UCLA/backend-reader/internal/symbolizer"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
 )
 
-const (
-	SERIAL_PORT_NAME = "/dev/cu.usbserial-0001"
-	UDP_LISTENER_PORT = ":8081"
-	QUEUE_CAPACITY = 20
-	RAW_PACKET_SIZE = 72
-)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		runExport(os.Args[2:])
+		return
+	}
 
-var STOP_SEQUENCE = [2]byte{'\r', '\n'}
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v\n", err)
+	}
 
+	// readers push into the raw queue, the recorder tees it into the message queue the processor reads from
+	rawQueue := make(chan tracereader.RawPacket, cfg.QueueCapacity)
+	messageQueue := make(chan tracereader.RawPacket, cfg.QueueCapacity)
+	registry := tracereader.NewRegistry()
+	replayController := replayreader.NewController()
 
-func main() {
-	messageQueue := make(chan [RAW_PACKET_SIZE]byte, QUEUE_CAPACITY)
-	// port := rSerial.NewRSerial(PORT_NAME, 460800, STOP_SEQUENCE[:], messageQueue)
-	// defer port.Close()
+	for _, readerCfg := range cfg.ReaderConfigs() {
+		var reader tracereader.TraceReader
+
+		switch readerCfg.Transport {
+		case config.TRANSPORT_SERIAL:
+			// already validated when the config was loaded
+			stopSequence, _ := readerCfg.StopSequenceBytes()
+			serialPort := rSerial.NewRSerial(readerCfg.Source, readerCfg.SerialPort, readerCfg.BaudRate, stopSequence, readerCfg.Framing, rawQueue)
+			defer serialPort.Close()
+
+			reader = serialPort
+		case config.TRANSPORT_UDP:
+			reader = udpreader.NewUDPReader(readerCfg.Source, readerCfg.UDPAddr, rawQueue)
+		case config.TRANSPORT_REPLAY:
+			// replays skip the recorder, there is no point recording what is already on disk
+			replay := replayreader.NewReplayReader(readerCfg.Source, readerCfg.ReplayFile, *readerCfg.ReplaySpeed, messageQueue)
+			replayController.Add(replay)
+
+			reader = replay
+		}
+
+		if err := registry.Register(readerCfg.Source, reader); err != nil {
+			log.Fatalf("Unable to register reader: %v\n", err)
+		}
+	}
+
+	recorder := recording.NewRecorder(cfg.RecordDir, cfg.RecordMaxBytes, cfg.RecordMaxDuration.Duration)
+	if cfg.RecordOnStart {
+		if err := recorder.Start(); err != nil {
+			log.Fatalf("Unable to start recording: %v\n", err)
+		}
+	}
 
-	port := udpreader.NewUDPReader(UDP_LISTENER_PORT, messageQueue)
 	socketManager := processing.NewSocketManager()
+	socketManager.QueueSize = cfg.ClientQueue
+	socketManager.DropPolicy = cfg.DropPolicy
+	socketManager.BatchInterval = cfg.BatchInterval.Duration
+
+	// alerts sit between the processor and the websocket clients when there are rules to check
+	var broadcaster processing.Broadcaster = socketManager
+	var alertEngine *alerting.Engine
+	if cfg.AlertRules != "" {
+		engine, err := alerting.NewEngine(cfg.AlertRules, cfg.AlertLog, socketManager)
+		if err != nil {
+			log.Fatalf("Unable to load alert rules: %v\n", err)
+		}
+		defer engine.Close()
+		go engine.WatchRules()
 
-	processor := processing.NewProcessor(UDP_LISTENER_PORT, messageQueue, socketManager)
+		alertEngine = engine
+		broadcaster = engine
+	}
+
+	processor := processing.NewProcessor(messageQueue, broadcaster)
+	processor.DetectPacketLoss = cfg.DetectPacketLoss
+	processor.CoreCount = uint32(cfg.Cores)
+	for _, periodic := range cfg.PeriodicFunctions {
+		processor.PeriodicFunctions = append(processor.PeriodicFunctions, processing.PeriodicFunction{
+			FuncName: periodic.Function,
+			Period: periodic.Period.Duration,
+			Deadline: periodic.Deadline.Duration,
+			MaxJitter: periodic.MaxJitter.Duration,
+		})
+	}
+
+	processor.SnapshotWindow = cfg.SnapshotWindow.Duration
+	processor.CallHistorySize = cfg.CallHistory
+	processor.CallHistoryBytes = cfg.CallHistoryBytes
+	socketManager.Snapshots = processor
+
+	processor.AnomalyThreshold = cfg.AnomalyThreshold
+	processor.AnomalyLearnFor = cfg.AnomalyLearn.Duration
+	if cfg.AnomalyBaseline != "" {
+		baseline, err := processing.BuildBaseline(cfg.AnomalyBaseline, uint32(cfg.Cores))
+		if err != nil {
+			log.Fatalf("Unable to build anomaly baseline from %s: %v\n", cfg.AnomalyBaseline, err)
+		}
+		processor.SetBaseline(baseline)
+	}
+
+	if cfg.FirmwareELF != "" {
+		firmwareSymbols, err := symbolizer.Load(cfg.FirmwareELF)
+		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		processor.SetSymbolizer("", firmwareSymbols)
+	}
 
 	http.HandleFunc("/data", func(w http.ResponseWriter, r *http.Request) {
 		conn, err := processing.Upgrader.Upgrade(w, r, nil)
@@ -38,17 +133,52 @@ func main() {
 		fmt.Println("New connection created")
 
 		for {
-			if _, _, err := conn.NextReader(); err != nil {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
 				socketManager.Unregister(conn)
 				break
-			}		
+			}
+
+			// clients can narrow down what they are sent, see processing.Subscription
+			socketManager.HandleClientMessage(conn, message)
 		}
 	})
 
 
-	go port.Run()
+	http.HandleFunc("/clients", socketManager.HandleClients)
+	http.HandleFunc("/readers", registry.HandleReaders)
+
+	http.HandleFunc("/record/start", recorder.HandleStart)
+	http.HandleFunc("/record/stop", recorder.HandleStop)
+	http.HandleFunc("/record/status", recorder.HandleStatus)
+
+	exportHandler := export.NewHandler(cfg.RecordDir, uint32(cfg.Cores))
+	http.HandleFunc("/export/chrome", exportHandler.HandleChromeTrace)
+	http.HandleFunc("/export/folded", processor.HandleFoldedStacks)
+	http.HandleFunc("/firmware/elf", processor.HandleFirmwareUpload)
+	http.HandleFunc("/stats/histogram", processor.HandleHistograms)
+	http.HandleFunc("/stats/reset", processor.HandleStatReset)
+	http.HandleFunc("/stats/boots", processor.HandleStatSegments)
+	http.HandleFunc("/stats/callgraph", processor.HandleCallGraph)
+	http.HandleFunc("/stats/baseline", processor.HandleBaselines)
+	http.HandleFunc("/calls", processor.HandleCalls)
+	http.HandleFunc("/calls/status", processor.HandleCallHistory)
+	if alertEngine != nil {
+		http.HandleFunc("/alerts/rules", alertEngine.HandleRules)
+	}
+
+	http.HandleFunc("/replay/status", replayController.HandleStatus)
+	http.HandleFunc("/replay/pause", replayController.HandlePause)
+	http.HandleFunc("/replay/resume", replayController.HandleResume)
+	http.HandleFunc("/replay/speed", replayController.HandleSpeed)
+	http.HandleFunc("/replay/seek", replayController.HandleSeek)
+
+	registry.RunAll()
+	go recorder.Tee(rawQueue, messageQueue)
 	go processor.Run()
 
-	fmt.Println("Server started on :8080")
-    http.ListenAndServe(":8080", nil)
-}
\ No newline at end of file
+	fmt.Printf("Server started on %s\n", cfg.HTTPAddr)
+	if err := http.ListenAndServe(cfg.HTTPAddr, nil); err != nil {
+		log.Fatalf("HTTP server stopped: %v\n", err)
+	}
+}
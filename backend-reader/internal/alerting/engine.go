@@ -0,0 +1,417 @@
+package alerting
+
+import (
+	"RP-UCLA/backend-reader/internal/processing"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+const (
+	// how often the rules file is checked for changes
+	RULES_POLL_INTERVAL = time.Second
+	WEBHOOK_TIMEOUT = 2 * time.Second
+	// alerts waiting to be POSTed, any more than this while the webhook is slow are dropped
+	WEBHOOK_QUEUE_SIZE = 64
+)
+
+// AlertEntry is sent over the websocket, appended to the alert log and POSTed to the webhook when a rule fires
+type AlertEntry struct {
+	TraceType		uint32		`json:"traceType"`
+	Rule			string		`json:"rule"`
+	When			string		`json:"when"`
+	Message			string		`json:"message"`
+	DeviceId		string		`json:"deviceId"`
+	CoreId			uint32		`json:"coreId"`
+	FuncName		string		`json:"funcName,omitempty"`
+	FuncNumId		uint32		`json:"funcCallId,omitempty"`
+	Timestamp		string		`json:"timestamp"`
+	PacketId		string		`json:"packetId"`
+}
+
+type webhookPost struct {
+	url			string
+	body		[]byte
+}
+
+type cooldownKey struct {
+	rule		string
+	deviceId	string
+}
+
+// Engine sits between the Processor and the real Broadcaster, passing everything through
+// and checking completed calls and events against the rules on the way
+type Engine struct {
+	mu			sync.Mutex
+	next		processing.Broadcaster
+	path		string
+	modTime		time.Time
+	rules		[]Rule
+	webhook		string
+
+	// timestamp of the last alert per rule and device, in microseconds
+	lastFired	map[cooldownKey]int64
+	fired		map[string]uint64
+
+	logFile		*os.File
+	client		*http.Client
+
+	// a single worker POSTs alerts one at a time, so a slow webhook can't pile up goroutines
+	webhooks		chan webhookPost
+	webhookDropped	uint64
+	done			chan struct{}
+}
+
+// NewEngine loads the rules file, alerts are appended to logPath as JSON lines unless it is empty
+func NewEngine(rulesPath string, logPath string, next processing.Broadcaster) (*Engine, error) {
+	e := &Engine{
+		next: next,
+		path: rulesPath,
+		lastFired: make(map[cooldownKey]int64),
+		fired: make(map[string]uint64),
+		client: &http.Client{Timeout: WEBHOOK_TIMEOUT},
+		webhooks: make(chan webhookPost, WEBHOOK_QUEUE_SIZE),
+		done: make(chan struct{}),
+	}
+
+	if err := e.reload(); err != nil {
+		return nil, err
+	}
+
+	if logPath != "" {
+		logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open alert log %s: %v", logPath, err)
+		}
+		e.logFile = logFile
+	}
+
+	go e.webhookLoop()
+
+	return e, nil
+}
+
+func (e *Engine) reload() error {
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return fmt.Errorf("unable to read rules file %s: %v", e.path, err)
+	}
+
+	ruleFile, rules, err := loadRuleFile(e.path)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.modTime = info.ModTime()
+	e.rules = rules
+	e.webhook = ruleFile.Webhook
+
+	return nil
+}
+
+// WatchRules reloads the rules file whenever it changes, a broken file keeps the previous rules running
+func (e *Engine) WatchRules() {
+	ticker := time.NewTicker(RULES_POLL_INTERVAL)
+
+	for range ticker.C {
+		info, err := os.Stat(e.path)
+		if err != nil {
+			continue
+		}
+
+		e.mu.Lock()
+		changed := !info.ModTime().Equal(e.modTime)
+		e.mu.Unlock()
+		if !changed {
+			continue
+		}
+
+		if err := e.reload(); err != nil {
+			log.Printf("Keeping the previous alert rules: %v\n", err)
+
+			// don't complain again until the file changes
+			e.mu.Lock()
+			e.modTime = info.ModTime()
+			e.mu.Unlock()
+			continue
+		}
+
+		log.Printf("Reloaded alert rules from %s\n", e.path)
+	}
+}
+
+func (e *Engine) Broadcast(data interface{}) {
+	e.next.Broadcast(data)
+
+	for _, alert := range e.evaluate(data) {
+		e.fire(alert)
+	}
+}
+
+func (e *Engine) evaluate(data interface{}) []AlertEntry {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	alerts := make([]AlertEntry, 0)
+	for idx := range e.rules {
+		rule := &e.rules[idx]
+
+		alert, ok := rule.match(data)
+		if !ok || (rule.Device != "" && rule.Device != alert.DeviceId) {
+			continue
+		}
+
+		timestamp, _ := strconv.ParseInt(alert.Timestamp, 10, 64)
+		key := cooldownKey{rule: rule.Name, deviceId: alert.DeviceId}
+		if last, ok := e.lastFired[key]; ok && timestamp - last < rule.Cooldown.Microseconds() && timestamp >= last {
+			continue
+		}
+		e.lastFired[key] = timestamp
+		e.fired[rule.Name]++
+
+		alert.TraceType = processing.ALERT
+		alert.Rule = rule.Name
+		alert.When = rule.When
+		alert.PacketId = xid.New().String()
+		alerts = append(alerts, alert)
+	}
+
+	return alerts
+}
+
+// match checks one broadcast entry against a rule, filling in everything but the rule details on a match
+func (r *Rule) match(data interface{}) (AlertEntry, bool) {
+	switch entry := data.(type) {
+	case *processing.FormattedCompletedFunctionCall:
+		return r.matchCall(entry)
+	case processing.FormattedTraceFunctionPanicEntry:
+		if r.event != EVENT_PANIC {
+			break
+		}
+
+		message := fmt.Sprintf("panic: %s at 0x%08x", processing.TrimCString(entry.ExceptionReason), entry.FaultingPC)
+		if entry.FaultFunction != "" {
+			message += fmt.Sprintf(" in %s (%s:%d)", entry.FaultFunction, entry.FaultFile, entry.FaultLine)
+		}
+
+		return AlertEntry{
+			Message: message,
+			DeviceId: entry.DeviceId,
+			CoreId: entry.CoreId,
+			FuncName: entry.FaultFunction,
+			Timestamp: entry.Timestamp,
+		}, true
+	case processing.FormattedTraceFunctionRestartEntry:
+		if r.event != EVENT_RESTART {
+			break
+		}
+
+		return AlertEntry{
+			Message: "restart: " + entry.RestartReason,
+			DeviceId: entry.DeviceId,
+			CoreId: entry.CoreId,
+			Timestamp: entry.Timestamp,
+		}, true
+	case processing.DeadlineMissEntry:
+		if r.event != EVENT_DEADLINE_MISS {
+			break
+		}
+
+		return AlertEntry{
+			Message: fmt.Sprintf("%s %s: ran for %dus against a %dus deadline, %dus since the previous start", entry.FuncName, entry.Kind, entry.Duration, entry.Deadline, entry.Period),
+			DeviceId: entry.DeviceId,
+			CoreId: entry.CoreId,
+			FuncName: entry.FuncName,
+			FuncNumId: entry.FuncNumId,
+			Timestamp: entry.StartTime,
+		}, true
+	case processing.PacketLossEntry:
+		if r.event != EVENT_PACKET_LOSS {
+			break
+		}
+
+		return AlertEntry{
+			Message: fmt.Sprintf("%d packets lost between trace IDs %d and %d", entry.PacketsLost, entry.LastTraceId, entry.ReceivedTraceId),
+			DeviceId: entry.DeviceId,
+			CoreId: entry.CoreId,
+			Timestamp: entry.Timestamp,
+		}, true
+	}
+
+	return AlertEntry{}, false
+}
+
+func (r *Rule) matchCall(call *processing.FormattedCompletedFunctionCall) (AlertEntry, bool) {
+	funcName := processing.TrimCString(call.FuncName)
+	if r.event != "" || (r.funcName != ANY_FUNCTION && r.funcName != funcName) {
+		return AlertEntry{}, false
+	}
+
+	value, ok := float64(0), true
+	switch r.field {
+	case FIELD_DURATION:
+		// the end of a truncated call is only a guess, so it can't break a timing rule
+		if call.Truncated {
+			return AlertEntry{}, false
+		}
+		value = float64(call.RunTime())
+	case FIELD_SELF_TIME:
+		if call.Truncated {
+			return AlertEntry{}, false
+		}
+		value = float64(call.SelfTime())
+	case FIELD_RETURN_VALUE:
+		// a truncated call never got its EXIT, so there is no return value to check
+		if call.Truncated {
+			return AlertEntry{}, false
+		}
+		value, ok = toFloat(call.ReturnVal)
+	default:
+		if r.argIdx >= int(call.ArgCount) {
+			return AlertEntry{}, false
+		}
+		value, ok = toFloat(call.FuncArgs[r.argIdx])
+	}
+
+	// a missing value is not 0, and must not match a rule like returnValue == 0
+	if !ok || !r.compare(value) {
+		return AlertEntry{}, false
+	}
+
+	return AlertEntry{
+		Message: fmt.Sprintf("%s %s was %v, rule is %s %v", funcName, r.field, value, r.op, r.threshold),
+		DeviceId: call.DeviceId,
+		CoreId: call.CoreId,
+		FuncName: funcName,
+		FuncNumId: call.FuncNumId,
+		Timestamp: call.EndTime,
+	}, true
+}
+
+func (e *Engine) fire(alert AlertEntry) {
+	e.next.Broadcast(alert)
+
+	encoded, err := json.Marshal(alert)
+	if err != nil {
+		fmt.Printf("Unable to encode alert: %v\n", err)
+		return
+	}
+
+	e.mu.Lock()
+	if e.logFile != nil {
+		if _, err := e.logFile.Write(append(encoded, '\n')); err != nil {
+			fmt.Printf("Unable to write alert log: %v\n", err)
+		}
+	}
+	webhook := e.webhook
+	e.mu.Unlock()
+
+	if webhook == "" {
+		return
+	}
+
+	// never hold up the processor waiting on somebody else's server
+	select {
+	case e.webhooks <- webhookPost{url: webhook, body: encoded}:
+	default:
+		e.mu.Lock()
+		e.webhookDropped++
+		first := e.webhookDropped == 1
+		e.mu.Unlock()
+
+		// the running count is in /alerts/rules, a busy rule would flood the log otherwise
+		if first {
+			fmt.Printf("Alert webhook %s is falling behind, dropping alerts until it catches up\n", webhook)
+		}
+	}
+}
+
+func (e *Engine) webhookLoop() {
+	for {
+		select {
+		case <-e.done:
+			return
+		case post := <-e.webhooks:
+			e.postWebhook(post.url, post.body)
+		}
+	}
+}
+
+func (e *Engine) postWebhook(url string, body []byte) {
+	resp, err := e.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Printf("Unable to POST alert to %s: %v\n", url, err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		fmt.Printf("Alert webhook %s answered %s\n", url, resp.Status)
+	}
+}
+
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	select {
+	case <-e.done:
+	default:
+		close(e.done)
+	}
+
+	if e.logFile == nil {
+		return nil
+	}
+
+	return e.logFile.Close()
+}
+
+// HandleRules serves the rules currently loaded along with how often each one has fired
+func (e *Engine) HandleRules(w http.ResponseWriter, req *http.Request) {
+	e.mu.Lock()
+	status := map[string]interface{}{
+		"file": e.path,
+		"webhook": e.webhook,
+		"webhookDropped": e.webhookDropped,
+		"rules": e.rules,
+		"fired": e.fired,
+	}
+	encoded, err := json.Marshal(status)
+	e.mu.Unlock()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(encoded)
+}
+
+// toFloat returns false for anything that isn't a number, such as the nil return value of a call without an EXIT
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,52 @@
+package alerting
+
+import (
+	"RP-UCLA/backend-reader/internal/processing"
+	"testing"
+)
+
+func TestMatchCall(t *testing.T) {
+	completed := func(returnVal interface{}, truncated bool) *processing.FormattedCompletedFunctionCall {
+		call := &processing.FormattedCompletedFunctionCall{
+			FuncName: "initRadio",
+			ArgCount: 1,
+			ReturnVal: returnVal,
+			StartTime: "1000",
+			EndTime: "5000",
+			Truncated: truncated,
+		}
+		call.FuncArgs[0] = uint32(0)
+
+		return call
+	}
+
+	tests := []struct {
+		name		string
+		when		string
+		call		*processing.FormattedCompletedFunctionCall
+		want		bool
+	}{
+		{"return value", "initRadio returnValue != 0", completed(int32(-1), false), true},
+		{"return value of 0", "initRadio returnValue == 0", completed(uint32(0), false), true},
+		{"truncated call has no return value", "initRadio returnValue == 0", completed(nil, true), false},
+		{"missing return value is not 0", "initRadio returnValue == 0", completed(nil, false), false},
+		{"argument", "initRadio arg0 == 0", completed(uint32(0), false), true},
+		{"argument past the arg count", "initRadio arg1 == 0", completed(uint32(0), false), false},
+		{"duration", "initRadio duration > 2ms", completed(uint32(0), false), true},
+		{"truncated call has no duration", "initRadio duration > 2ms", completed(uint32(0), true), false},
+		{"other function", "readIMU returnValue == 0", completed(uint32(0), false), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := parseRule(RuleConfig{When: test.when})
+			if err != nil {
+				t.Fatalf("parseRule(%q): %v", test.when, err)
+			}
+
+			if _, got := rule.matchCall(test.call); got != test.want {
+				t.Errorf("%q matched = %v, want %v", test.when, got, test.want)
+			}
+		})
+	}
+}
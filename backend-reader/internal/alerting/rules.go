@@ -0,0 +1,209 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// events that fire a rule on their own, written as the whole condition, e.g. "when": "panic"
+const (
+	EVENT_PANIC = "panic"
+	EVENT_RESTART = "restart"
+	EVENT_DEADLINE_MISS = "deadline_miss"
+	EVENT_PACKET_LOSS = "packet_loss"
+)
+
+// fields of a completed call a condition can look at, durations are compared in microseconds
+const (
+	FIELD_DURATION = "duration"
+	FIELD_SELF_TIME = "selfTime"
+	FIELD_RETURN_VALUE = "returnValue"
+	FIELD_ARG_PREFIX = "arg"
+
+	// matches every function in a condition such as "* duration > 50ms"
+	ANY_FUNCTION = "*"
+
+	// rules fire at most once a second per device unless they say otherwise,
+	// otherwise a broken 100Hz loop would fire a hundred webhooks a second
+	DEFAULT_COOLDOWN = time.Second
+)
+
+// RuleFile is the JSON rules file, for example
+//
+//	{
+//		"webhook": "http://localhost:9000/alerts",
+//		"rules": [
+//			{"name": "slow IMU", "when": "readIMU duration > 2ms"},
+//			{"name": "radio init failed", "when": "initRadio returnValue != 0", "cooldown": "0s"},
+//			{"name": "panic", "when": "panic"}
+//		]
+//	}
+type RuleFile struct {
+	// completed alerts are POSTed here as JSON when set
+	Webhook		string			`json:"webhook"`
+	Rules		[]RuleConfig	`json:"rules"`
+}
+
+type RuleConfig struct {
+	Name		string		`json:"name"`
+	When		string		`json:"when"`
+	// only match packets from this source, empty for every device
+	Device		string		`json:"device"`
+	// minimum time between two alerts of this rule for the same device, such as "500ms"
+	Cooldown	string		`json:"cooldown"`
+}
+
+// Rule is a parsed RuleConfig
+type Rule struct {
+	Name		string
+	When		string
+	Device		string
+	Cooldown	time.Duration
+
+	// set for event rules, everything below is only used for conditions on completed calls
+	event		string
+	funcName	string
+	field		string
+	argIdx		int
+	op			string
+	threshold	float64
+}
+
+func loadRuleFile(path string) (*RuleFile, []Rule, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read rules file %s: %v", path, err)
+	}
+
+	ruleFile := RuleFile{}
+	if err := json.Unmarshal(contents, &ruleFile); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse rules file %s: %v", path, err)
+	}
+
+	rules := make([]Rule, 0, len(ruleFile.Rules))
+	for idx, ruleConfig := range ruleFile.Rules {
+		rule, err := parseRule(ruleConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("rule %d in %s: %v", idx, path, err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return &ruleFile, rules, nil
+}
+
+func parseRule(ruleConfig RuleConfig) (Rule, error) {
+	rule := Rule{
+		Name: ruleConfig.Name,
+		When: strings.TrimSpace(ruleConfig.When),
+		Device: ruleConfig.Device,
+		Cooldown: DEFAULT_COOLDOWN,
+	}
+	if rule.Name == "" {
+		rule.Name = rule.When
+	}
+
+	if ruleConfig.Cooldown != "" {
+		cooldown, err := time.ParseDuration(ruleConfig.Cooldown)
+		if err != nil || cooldown < 0 {
+			return rule, fmt.Errorf("cooldown should be a duration such as 500ms, got %q", ruleConfig.Cooldown)
+		}
+		rule.Cooldown = cooldown
+	}
+
+	switch rule.When {
+	case EVENT_PANIC, EVENT_RESTART, EVENT_DEADLINE_MISS, EVENT_PACKET_LOSS:
+		rule.event = rule.When
+		return rule, nil
+	}
+
+	// everything else is a condition on completed calls, "<function> <field> <op> <value>"
+	parts := strings.Fields(rule.When)
+	if len(parts) != 4 {
+		return rule, fmt.Errorf("%q should be an event (%s, %s, %s or %s) or look like \"readIMU duration > 2ms\"",
+			rule.When, EVENT_PANIC, EVENT_RESTART, EVENT_DEADLINE_MISS, EVENT_PACKET_LOSS)
+	}
+	rule.funcName, rule.field, rule.op = parts[0], parts[1], parts[2]
+
+	switch rule.op {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return rule, fmt.Errorf("unknown comparison %q in %q", rule.op, rule.When)
+	}
+
+	switch {
+	case rule.field == FIELD_DURATION || rule.field == FIELD_SELF_TIME:
+		threshold, err := parseMicros(parts[3])
+		if err != nil {
+			return rule, fmt.Errorf("%q: %v", rule.When, err)
+		}
+		rule.threshold = threshold
+		return rule, nil
+	case rule.field == FIELD_RETURN_VALUE:
+	case strings.HasPrefix(rule.field, FIELD_ARG_PREFIX):
+		argIdx, err := strconv.Atoi(strings.TrimPrefix(rule.field, FIELD_ARG_PREFIX))
+		if err != nil || argIdx < 0 || argIdx > 3 {
+			return rule, fmt.Errorf("unknown argument %q in %q, expected arg0 to arg3", rule.field, rule.When)
+		}
+		rule.argIdx = argIdx
+	default:
+		return rule, fmt.Errorf("unknown field %q in %q, expected %s, %s, %s or arg0 to arg3",
+			rule.field, rule.When, FIELD_DURATION, FIELD_SELF_TIME, FIELD_RETURN_VALUE)
+	}
+
+	threshold, err := strconv.ParseFloat(parts[3], 64)
+	if err != nil {
+		return rule, fmt.Errorf("%q: %q is not a number", rule.When, parts[3])
+	}
+	rule.threshold = threshold
+
+	return rule, nil
+}
+
+// MarshalJSON writes the rule back out the way it is written in the rules file
+func (r Rule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(RuleConfig{
+		Name: r.Name,
+		When: r.When,
+		Device: r.Device,
+		Cooldown: r.Cooldown.String(),
+	})
+}
+
+// parseMicros takes either a duration such as 2ms or a plain number of microseconds
+func parseMicros(value string) (float64, error) {
+	if micros, err := strconv.ParseFloat(value, 64); err == nil {
+		return micros, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q should be a duration such as 2ms", value)
+	}
+
+	return float64(duration.Microseconds()), nil
+}
+
+func (r *Rule) compare(value float64) bool {
+	switch r.op {
+	case ">":
+		return value > r.threshold
+	case ">=":
+		return value >= r.threshold
+	case "<":
+		return value < r.threshold
+	case "<=":
+		return value <= r.threshold
+	case "==":
+		return value == r.threshold
+	case "!=":
+		return value != r.threshold
+	}
+
+	return false
+}
@@ -0,0 +1,706 @@
+package config
+
+import (
+	"RP-UCLA/backend-reader/internal/traceReader/rSerial"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TRANSPORT_SERIAL = "serial"
+	TRANSPORT_UDP    = "udp"
+	TRANSPORT_REPLAY = "replay"
+
+	// these match the drop policies in the processing package
+	DROP_OLDEST      = "drop-oldest"
+	DROP_NONCRITICAL = "drop-noncritical"
+	DISCONNECT       = "disconnect"
+
+	ENV_PREFIX = "HERMES_"
+)
+
+// Duration lets durations be written as strings like "10m" in the config file
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("durations should be strings such as \"90s\": %v", err)
+	}
+
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// ReaderConfig describes one trace reader, any field left empty falls back to the top level value
+type ReaderConfig struct {
+	Source       string `json:"source"`
+	Transport    string `json:"transport"`
+	SerialPort   string `json:"serialPort"`
+	BaudRate     int    `json:"baudRate"`
+	StopSequence string `json:"stopSequence"`
+	Framing      string `json:"framing"`
+	UDPAddr      string `json:"udpAddr"`
+	ReplayFile   string `json:"replayFile"`
+	// 1 replays at the original speed, 0 as fast as possible
+	ReplaySpeed *float64 `json:"replaySpeed"`
+}
+
+// PeriodicFunctionConfig is a root function expected to start every Period, such as loop() at 100Hz
+type PeriodicFunctionConfig struct {
+	Function string   `json:"function"`
+	Period   Duration `json:"period"`
+	// how long a single call may take, defaults to the period
+	Deadline Duration `json:"deadline"`
+	// how far the start-to-start time may drift from the period, 0 to only check deadlines
+	MaxJitter Duration `json:"maxJitter"`
+}
+
+// periodicFlags collects repeated -periodic flags of the form function:period[:deadline[:jitter]]
+type periodicFlags []PeriodicFunctionConfig
+
+func (p *periodicFlags) String() string {
+	specs := make([]string, 0, len(*p))
+	for _, periodic := range *p {
+		specs = append(specs, fmt.Sprintf("%s:%s:%s:%s", periodic.Function, periodic.Period, periodic.Deadline, periodic.MaxJitter))
+	}
+
+	return strings.Join(specs, ",")
+}
+
+func (p *periodicFlags) Set(spec string) error {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 4 {
+		return fmt.Errorf("periodic function %q should look like function:period[:deadline[:jitter]]", spec)
+	}
+
+	durations := make([]Duration, 3)
+	for idx, part := range parts[1:] {
+		// leaving a field empty keeps its default, as in loop:10ms::1ms
+		if part == "" {
+			continue
+		}
+
+		parsed, err := time.ParseDuration(part)
+		if err != nil {
+			return fmt.Errorf("periodic function %q: %v", spec, err)
+		}
+		durations[idx] = Duration{parsed}
+	}
+
+	*p = append(*p, PeriodicFunctionConfig{
+		Function:  parts[0],
+		Period:    durations[0],
+		Deadline:  durations[1],
+		MaxJitter: durations[2],
+	})
+	return nil
+}
+
+// Config holds everything that used to be hard-coded in cmd/main.go
+//
+// values are resolved in the following order, with later sources winning:
+// defaults -> config file -> environment variables -> command line flags
+type Config struct {
+	Transport     string  `json:"transport"`
+	SerialPort    string  `json:"serialPort"`
+	BaudRate      int     `json:"baudRate"`
+	StopSequence  string  `json:"stopSequence"`
+	Framing       string  `json:"framing"`
+	UDPAddr       string  `json:"udpAddr"`
+	HTTPAddr      string  `json:"httpAddr"`
+	QueueCapacity int     `json:"queueCapacity"`
+	ReplayFile    string  `json:"replayFile"`
+	ReplaySpeed   float64 `json:"replaySpeed"`
+
+	// Source names the reader built from the top level transport settings
+	Source string `json:"source"`
+	// Readers lists every reader to run at the same time, when empty a single reader is built from the top level settings
+	Readers []ReaderConfig `json:"readers"`
+
+	// session recording
+	RecordDir         string   `json:"recordDir"`
+	RecordOnStart     bool     `json:"recordOnStart"`
+	RecordMaxBytes    int64    `json:"recordMaxBytes"`
+	RecordMaxDuration Duration `json:"recordMaxDuration"`
+
+	// firmware ELF used to symbolicate panics
+	FirmwareELF string `json:"firmwareElf"`
+
+	// report gaps in TraceIds as lost packets
+	DetectPacketLoss bool `json:"detectPacketLoss"`
+	// number of cores on the traced chips, packets from any other core are reported and dropped
+	Cores int `json:"cores"`
+
+	// root functions whose period and run time are watched for deadline misses
+	PeriodicFunctions []PeriodicFunctionConfig `json:"periodicFunctions"`
+
+	// JSON file of alert rules, reloaded whenever it changes
+	AlertRules string `json:"alertRules"`
+	// fired alerts are appended here, empty to skip logging
+	AlertLog string `json:"alertLog"`
+
+	// anomaly detection scores calls against a reference recording, or failing that the first AnomalyLearn of every device
+	AnomalyBaseline  string   `json:"anomalyBaseline"`
+	AnomalyLearn     Duration `json:"anomalyLearn"`
+	AnomalyThreshold float64  `json:"anomalyThreshold"`
+
+	// messages queued per websocket client, and what happens to a client that falls further behind
+	ClientQueue int    `json:"clientQueue"`
+	DropPolicy  string `json:"dropPolicy"`
+	// how often clients on a batch subprotocol are sent a frame
+	BatchInterval Duration `json:"batchInterval"`
+	// completed calls from this far back are sent to clients as they connect
+	SnapshotWindow Duration `json:"snapshotWindow"`
+
+	// completed calls kept per device for /calls, the oldest are dropped once either limit is reached
+	CallHistory      int   `json:"callHistory"`
+	CallHistoryBytes int64 `json:"callHistoryBytes"`
+}
+
+// readerFlags collects repeated -reader flags of the form source=transport:address
+type readerFlags []ReaderConfig
+
+func (r *readerFlags) String() string {
+	specs := make([]string, 0, len(*r))
+	for _, reader := range *r {
+		address := reader.SerialPort
+		switch reader.Transport {
+		case TRANSPORT_UDP:
+			address = reader.UDPAddr
+		case TRANSPORT_REPLAY:
+			address = reader.ReplayFile
+		}
+		specs = append(specs, fmt.Sprintf("%s=%s:%s", reader.Source, reader.Transport, address))
+	}
+
+	return strings.Join(specs, ",")
+}
+
+func (r *readerFlags) Set(spec string) error {
+	source, rest, ok := strings.Cut(spec, "=")
+	if !ok {
+		return fmt.Errorf("reader %q should look like source=transport:address", spec)
+	}
+
+	transport, address, ok := strings.Cut(rest, ":")
+	if !ok {
+		return fmt.Errorf("reader %q should look like source=transport:address", spec)
+	}
+
+	reader := ReaderConfig{
+		Source:    source,
+		Transport: strings.ToLower(transport),
+	}
+
+	switch reader.Transport {
+	case TRANSPORT_SERIAL:
+		reader.SerialPort = address
+	case TRANSPORT_UDP:
+		reader.UDPAddr = address
+	case TRANSPORT_REPLAY:
+		reader.ReplayFile = address
+	default:
+		return fmt.Errorf("unknown transport %q in reader %q", transport, spec)
+	}
+
+	*r = append(*r, reader)
+	return nil
+}
+
+func Default() *Config {
+	return &Config{
+		Transport:        TRANSPORT_UDP,
+		SerialPort:       "/dev/cu.usbserial-0001",
+		BaudRate:         460800,
+		StopSequence:     `\r\n`,
+		Framing:          rSerial.FRAMING_STOP_SEQUENCE,
+		UDPAddr:          ":8081",
+		HTTPAddr:         ":8080",
+		QueueCapacity:    20,
+		ReplaySpeed:      1,
+		RecordDir:        "recordings",
+		Cores:            2,
+		AlertLog:         "alerts.log",
+		AnomalyLearn:     Duration{30 * time.Second},
+		AnomalyThreshold: 3.5,
+		ClientQueue:      256,
+		DropPolicy:       DROP_OLDEST,
+		BatchInterval:    Duration{50 * time.Millisecond},
+		SnapshotWindow:   Duration{10 * time.Second},
+		CallHistory:      100000,
+		CallHistoryBytes: 64 << 20,
+	}
+}
+
+// Load builds the config from the command line arguments (without the program name)
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	flagValues := Default()
+
+	fs := flag.NewFlagSet("backend-reader", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv(ENV_PREFIX+"CONFIG"), "path to a JSON config file")
+	fs.StringVar(&flagValues.Transport, "transport", cfg.Transport, "trace transport to use (serial, udp or replay)")
+	fs.StringVar(&flagValues.SerialPort, "serial-port", cfg.SerialPort, "serial device the board is attached to")
+	fs.IntVar(&flagValues.BaudRate, "baud", cfg.BaudRate, "serial baud rate")
+	fs.StringVar(&flagValues.StopSequence, "stop-sequence", cfg.StopSequence, "2 byte sequence terminating every serial packet, escapes such as \\r\\n are allowed")
+	fs.StringVar(&flagValues.Framing, "framing", cfg.Framing, "serial framing, stop-sequence for the original protocol or crc16 for magic + length + CRC16 frames")
+	fs.StringVar(&flagValues.UDPAddr, "udp-addr", cfg.UDPAddr, "address the UDP listener binds to")
+	fs.StringVar(&flagValues.HTTPAddr, "http-addr", cfg.HTTPAddr, "address the HTTP / websocket server listens on")
+	fs.IntVar(&flagValues.QueueCapacity, "queue", cfg.QueueCapacity, "number of raw packets buffered between the reader and the processor")
+	fs.StringVar(&flagValues.ReplayFile, "replay-file", cfg.ReplayFile, "session file to replay when using the replay transport")
+	fs.Float64Var(&flagValues.ReplaySpeed, "replay-speed", cfg.ReplaySpeed, "replay speed multiplier, 0 replays as fast as possible")
+	fs.StringVar(&flagValues.Source, "source", cfg.Source, "source identifier of the reader built from the top level flags, defaults to the device or bind address")
+	fs.StringVar(&flagValues.RecordDir, "record-dir", cfg.RecordDir, "directory session recordings are written to")
+	fs.BoolVar(&flagValues.RecordOnStart, "record", cfg.RecordOnStart, "start recording a session as soon as the server starts")
+	fs.Int64Var(&flagValues.RecordMaxBytes, "record-max-bytes", cfg.RecordMaxBytes, "rotate to a new session file after this many bytes, 0 to disable")
+	fs.DurationVar(&flagValues.RecordMaxDuration.Duration, "record-max-duration", cfg.RecordMaxDuration.Duration, "rotate to a new session file after this long, 0 to disable")
+	fs.StringVar(&flagValues.FirmwareELF, "elf", cfg.FirmwareELF, "firmware ELF with debug info, used to resolve panic addresses to source lines")
+	fs.BoolVar(&flagValues.DetectPacketLoss, "detect-loss", cfg.DetectPacketLoss, "treat gaps in TraceIds as lost packets, only for firmware that numbers every packet consecutively")
+	fs.IntVar(&flagValues.Cores, "cores", cfg.Cores, "number of cores on the traced chips")
+	fs.StringVar(&flagValues.AlertRules, "alert-rules", cfg.AlertRules, "JSON file of alert rules, reloaded whenever it changes")
+	fs.StringVar(&flagValues.AlertLog, "alert-log", cfg.AlertLog, "file fired alerts are appended to, empty to skip logging")
+	fs.StringVar(&flagValues.AnomalyBaseline, "anomaly-baseline", cfg.AnomalyBaseline, "recorded session of normal behaviour that run times are scored against")
+	fs.DurationVar(&flagValues.AnomalyLearn.Duration, "anomaly-learn", cfg.AnomalyLearn.Duration, "without a baseline recording, learn normal run times from the first this long of every device, 0 to turn off")
+	fs.Float64Var(&flagValues.AnomalyThreshold, "anomaly-threshold", cfg.AnomalyThreshold, "modified z-score above which a call is flagged as anomalous")
+	fs.IntVar(&flagValues.ClientQueue, "client-queue", cfg.ClientQueue, "messages buffered for each websocket client")
+	fs.StringVar(&flagValues.DropPolicy, "drop-policy", cfg.DropPolicy, "what to do when a websocket client's queue is full: drop-oldest, drop-noncritical or disconnect")
+	fs.DurationVar(&flagValues.BatchInterval.Duration, "batch-interval", cfg.BatchInterval.Duration, "how often websocket clients on a batch subprotocol are sent a frame")
+	fs.DurationVar(&flagValues.SnapshotWindow.Duration, "snapshot-window", cfg.SnapshotWindow.Duration, "how far back the completed calls sent to newly connected websocket clients go, 0 to send none")
+	fs.IntVar(&flagValues.CallHistory, "call-history", cfg.CallHistory, "completed calls kept per device for /calls")
+	fs.Int64Var(&flagValues.CallHistoryBytes, "call-history-bytes", cfg.CallHistoryBytes, "rough memory limit in bytes of the calls kept per device for /calls, 0 for no limit")
+	periodics := periodicFlags{}
+	fs.Var(&periodics, "periodic", "watch a root function for deadline misses, formatted as loop:10ms[:deadline[:jitter]] (repeatable)")
+	readers := readerFlags{}
+	fs.Var(&readers, "reader", "run an extra reader, formatted as source=serial:/dev/ttyUSB0, source=udp::8081 or source=replay:flight.hrec (repeatable)")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	// only flags that were explicitly passed should override the file and the environment
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "transport":
+			cfg.Transport = flagValues.Transport
+		case "serial-port":
+			cfg.SerialPort = flagValues.SerialPort
+		case "baud":
+			cfg.BaudRate = flagValues.BaudRate
+		case "stop-sequence":
+			cfg.StopSequence = flagValues.StopSequence
+		case "framing":
+			cfg.Framing = flagValues.Framing
+		case "udp-addr":
+			cfg.UDPAddr = flagValues.UDPAddr
+		case "http-addr":
+			cfg.HTTPAddr = flagValues.HTTPAddr
+		case "queue":
+			cfg.QueueCapacity = flagValues.QueueCapacity
+		case "replay-file":
+			cfg.ReplayFile = flagValues.ReplayFile
+		case "replay-speed":
+			cfg.ReplaySpeed = flagValues.ReplaySpeed
+		case "source":
+			cfg.Source = flagValues.Source
+		case "reader":
+			cfg.Readers = append(cfg.Readers, readers...)
+		case "record-dir":
+			cfg.RecordDir = flagValues.RecordDir
+		case "record":
+			cfg.RecordOnStart = flagValues.RecordOnStart
+		case "record-max-bytes":
+			cfg.RecordMaxBytes = flagValues.RecordMaxBytes
+		case "record-max-duration":
+			cfg.RecordMaxDuration = flagValues.RecordMaxDuration
+		case "elf":
+			cfg.FirmwareELF = flagValues.FirmwareELF
+		case "detect-loss":
+			cfg.DetectPacketLoss = flagValues.DetectPacketLoss
+		case "cores":
+			cfg.Cores = flagValues.Cores
+		case "alert-rules":
+			cfg.AlertRules = flagValues.AlertRules
+		case "alert-log":
+			cfg.AlertLog = flagValues.AlertLog
+		case "anomaly-baseline":
+			cfg.AnomalyBaseline = flagValues.AnomalyBaseline
+		case "anomaly-learn":
+			cfg.AnomalyLearn = flagValues.AnomalyLearn
+		case "anomaly-threshold":
+			cfg.AnomalyThreshold = flagValues.AnomalyThreshold
+		case "client-queue":
+			cfg.ClientQueue = flagValues.ClientQueue
+		case "drop-policy":
+			cfg.DropPolicy = flagValues.DropPolicy
+		case "batch-interval":
+			cfg.BatchInterval = flagValues.BatchInterval
+		case "snapshot-window":
+			cfg.SnapshotWindow = flagValues.SnapshotWindow
+		case "call-history":
+			cfg.CallHistory = flagValues.CallHistory
+		case "call-history-bytes":
+			cfg.CallHistoryBytes = flagValues.CallHistoryBytes
+		case "periodic":
+			cfg.PeriodicFunctions = append(cfg.PeriodicFunctions, periodics...)
+		}
+	})
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file %s: %v", path, err)
+	}
+
+	if err := json.Unmarshal(contents, c); err != nil {
+		return fmt.Errorf("unable to parse config file %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// loadEnv reads HERMES_<FLAG NAME> for every flag that takes a single value, e.g. HERMES_SERIAL_PORT for -serial-port
+func (c *Config) loadEnv() error {
+	stringVars := map[string]*string{
+		"TRANSPORT":        &c.Transport,
+		"SERIAL_PORT":      &c.SerialPort,
+		"STOP_SEQUENCE":    &c.StopSequence,
+		"FRAMING":          &c.Framing,
+		"UDP_ADDR":         &c.UDPAddr,
+		"HTTP_ADDR":        &c.HTTPAddr,
+		"SOURCE":           &c.Source,
+		"RECORD_DIR":       &c.RecordDir,
+		"REPLAY_FILE":      &c.ReplayFile,
+		"ELF":              &c.FirmwareELF,
+		"ALERT_RULES":      &c.AlertRules,
+		"ALERT_LOG":        &c.AlertLog,
+		"ANOMALY_BASELINE": &c.AnomalyBaseline,
+		"DROP_POLICY":      &c.DropPolicy,
+	}
+	intVars := map[string]*int{
+		"BAUD":         &c.BaudRate,
+		"QUEUE":        &c.QueueCapacity,
+		"CORES":        &c.Cores,
+		"CLIENT_QUEUE": &c.ClientQueue,
+		"CALL_HISTORY": &c.CallHistory,
+	}
+	int64Vars := map[string]*int64{
+		"RECORD_MAX_BYTES":   &c.RecordMaxBytes,
+		"CALL_HISTORY_BYTES": &c.CallHistoryBytes,
+	}
+	floatVars := map[string]*float64{
+		"REPLAY_SPEED":      &c.ReplaySpeed,
+		"ANOMALY_THRESHOLD": &c.AnomalyThreshold,
+	}
+	boolVars := map[string]*bool{
+		"RECORD":      &c.RecordOnStart,
+		"DETECT_LOSS": &c.DetectPacketLoss,
+	}
+	durationVars := map[string]*time.Duration{
+		"RECORD_MAX_DURATION": &c.RecordMaxDuration.Duration,
+		"ANOMALY_LEARN":       &c.AnomalyLearn.Duration,
+		"BATCH_INTERVAL":      &c.BatchInterval.Duration,
+		"SNAPSHOT_WINDOW":     &c.SnapshotWindow.Duration,
+	}
+
+	// a misspelt variable would otherwise be ignored without a word. -periodic and -reader take lists, so they only
+	// come from flags or the config file
+	known := map[string]bool{"CONFIG": true}
+	for name := range stringVars {
+		known[name] = true
+	}
+	for name := range intVars {
+		known[name] = true
+	}
+	for name := range int64Vars {
+		known[name] = true
+	}
+	for name := range floatVars {
+		known[name] = true
+	}
+	for name := range boolVars {
+		known[name] = true
+	}
+	for name := range durationVars {
+		known[name] = true
+	}
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		if strings.HasPrefix(name, ENV_PREFIX) && !known[strings.TrimPrefix(name, ENV_PREFIX)] {
+			return fmt.Errorf("unknown environment variable %s, expected one of %s", name, strings.Join(envNames(known), ", "))
+		}
+	}
+
+	for name, dst := range stringVars {
+		if val, ok := os.LookupEnv(ENV_PREFIX + name); ok {
+			*dst = val
+		}
+	}
+
+	for name, dst := range intVars {
+		if val, ok := os.LookupEnv(ENV_PREFIX + name); ok {
+			parsed, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("%s%s must be an integer, got %q", ENV_PREFIX, name, val)
+			}
+			*dst = parsed
+		}
+	}
+
+	for name, dst := range int64Vars {
+		if val, ok := os.LookupEnv(ENV_PREFIX + name); ok {
+			parsed, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s%s must be an integer, got %q", ENV_PREFIX, name, val)
+			}
+			*dst = parsed
+		}
+	}
+
+	for name, dst := range floatVars {
+		if val, ok := os.LookupEnv(ENV_PREFIX + name); ok {
+			parsed, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return fmt.Errorf("%s%s must be a number, got %q", ENV_PREFIX, name, val)
+			}
+			*dst = parsed
+		}
+	}
+
+	for name, dst := range boolVars {
+		if val, ok := os.LookupEnv(ENV_PREFIX + name); ok {
+			parsed, err := strconv.ParseBool(val)
+			if err != nil {
+				return fmt.Errorf("%s%s must be true or false, got %q", ENV_PREFIX, name, val)
+			}
+			*dst = parsed
+		}
+	}
+
+	for name, dst := range durationVars {
+		if val, ok := os.LookupEnv(ENV_PREFIX + name); ok {
+			parsed, err := time.ParseDuration(val)
+			if err != nil {
+				return fmt.Errorf("%s%s must be a duration such as 10s, got %q", ENV_PREFIX, name, val)
+			}
+			*dst = parsed
+		}
+	}
+
+	return nil
+}
+
+// envNames lists the supported variables with their prefix, sorted
+func envNames(known map[string]bool) []string {
+	names := make([]string, 0, len(known))
+	for name := range known {
+		names = append(names, ENV_PREFIX+name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (c *Config) validate() error {
+	if c.QueueCapacity < 0 {
+		return fmt.Errorf("queue capacity cannot be negative, got %d", c.QueueCapacity)
+	}
+
+	if c.Cores <= 0 {
+		return fmt.Errorf("core count must be positive, got %d", c.Cores)
+	}
+
+	if c.RecordMaxBytes < 0 || c.RecordMaxDuration.Duration < 0 {
+		return fmt.Errorf("recording rotation limits cannot be negative")
+	}
+
+	if c.ClientQueue <= 0 {
+		return fmt.Errorf("client queue size must be positive, got %d", c.ClientQueue)
+	}
+
+	if c.DropPolicy != DROP_OLDEST && c.DropPolicy != DROP_NONCRITICAL && c.DropPolicy != DISCONNECT {
+		return fmt.Errorf("unknown drop policy %q, expected %s, %s or %s", c.DropPolicy, DROP_OLDEST, DROP_NONCRITICAL, DISCONNECT)
+	}
+
+	if c.BatchInterval.Duration <= 0 {
+		return fmt.Errorf("batch interval must be positive, got %s", c.BatchInterval.Duration)
+	}
+
+	if c.SnapshotWindow.Duration < 0 {
+		return fmt.Errorf("snapshot window cannot be negative, got %s", c.SnapshotWindow.Duration)
+	}
+
+	if c.CallHistory <= 0 || c.CallHistoryBytes < 0 {
+		return fmt.Errorf("the call history must hold at least one call and its memory limit cannot be negative")
+	}
+
+	if c.AnomalyLearn.Duration < 0 || c.AnomalyThreshold <= 0 {
+		return fmt.Errorf("the anomaly learning time cannot be negative and the threshold must be positive")
+	}
+
+	for _, periodic := range c.PeriodicFunctions {
+		if periodic.Function == "" {
+			return fmt.Errorf("periodic functions need a function name")
+		}
+		if periodic.Period.Duration <= 0 {
+			return fmt.Errorf("periodic function %s needs a positive period, got %v", periodic.Function, periodic.Period)
+		}
+		if periodic.Deadline.Duration < 0 || periodic.MaxJitter.Duration < 0 {
+			return fmt.Errorf("periodic function %s cannot have a negative deadline or jitter", periodic.Function)
+		}
+	}
+
+	seenSources := make(map[string]bool)
+	for _, reader := range c.ReaderConfigs() {
+		if seenSources[reader.Source] {
+			return fmt.Errorf("reader source %q is used more than once", reader.Source)
+		}
+		seenSources[reader.Source] = true
+
+		if err := reader.validate(); err != nil {
+			return fmt.Errorf("reader %s: %v", reader.Source, err)
+		}
+	}
+
+	return nil
+}
+
+// ReaderConfigs resolves every reader to run, filling in anything left empty from the top level settings
+func (c *Config) ReaderConfigs() []ReaderConfig {
+	if len(c.Readers) == 0 {
+		return []ReaderConfig{c.fillReader(ReaderConfig{Source: c.Source})}
+	}
+
+	readers := make([]ReaderConfig, 0, len(c.Readers))
+	for _, reader := range c.Readers {
+		readers = append(readers, c.fillReader(reader))
+	}
+
+	return readers
+}
+
+func (c *Config) fillReader(reader ReaderConfig) ReaderConfig {
+	if reader.Transport == "" {
+		reader.Transport = c.Transport
+	}
+	reader.Transport = strings.ToLower(reader.Transport)
+
+	if reader.SerialPort == "" {
+		reader.SerialPort = c.SerialPort
+	}
+	if reader.BaudRate == 0 {
+		reader.BaudRate = c.BaudRate
+	}
+	if reader.StopSequence == "" {
+		reader.StopSequence = c.StopSequence
+	}
+	if reader.Framing == "" {
+		reader.Framing = c.Framing
+	}
+	reader.Framing = strings.ToLower(reader.Framing)
+	if reader.UDPAddr == "" {
+		reader.UDPAddr = c.UDPAddr
+	}
+	if reader.ReplayFile == "" {
+		reader.ReplayFile = c.ReplayFile
+	}
+	if reader.ReplaySpeed == nil {
+		speed := c.ReplaySpeed
+		reader.ReplaySpeed = &speed
+	}
+
+	if reader.Source == "" {
+		switch reader.Transport {
+		case TRANSPORT_UDP:
+			reader.Source = reader.UDPAddr
+		case TRANSPORT_REPLAY:
+			reader.Source = reader.ReplayFile
+		default:
+			reader.Source = reader.SerialPort
+		}
+	}
+
+	return reader
+}
+
+func (r ReaderConfig) validate() error {
+	if r.Transport != TRANSPORT_SERIAL && r.Transport != TRANSPORT_UDP && r.Transport != TRANSPORT_REPLAY {
+		return fmt.Errorf("unknown transport %q, expected %s, %s or %s", r.Transport, TRANSPORT_SERIAL, TRANSPORT_UDP, TRANSPORT_REPLAY)
+	}
+
+	if r.Transport == TRANSPORT_REPLAY {
+		if r.ReplayFile == "" {
+			return fmt.Errorf("a session file is needed to replay")
+		}
+		if *r.ReplaySpeed < 0 {
+			return fmt.Errorf("replay speed cannot be negative, got %v", *r.ReplaySpeed)
+		}
+	}
+
+	if r.BaudRate <= 0 {
+		return fmt.Errorf("baud rate must be positive, got %d", r.BaudRate)
+	}
+
+	if r.Framing != rSerial.FRAMING_STOP_SEQUENCE && r.Framing != rSerial.FRAMING_CRC16 {
+		return fmt.Errorf("unknown framing %q, expected %s or %s", r.Framing, rSerial.FRAMING_STOP_SEQUENCE, rSerial.FRAMING_CRC16)
+	}
+
+	stopSequence, err := r.StopSequenceBytes()
+	if err != nil {
+		return err
+	}
+	if len(stopSequence) != 2 {
+		return fmt.Errorf("stop sequence must be exactly 2 bytes, got %d", len(stopSequence))
+	}
+
+	return nil
+}
+
+// StopSequenceBytes resolves escape sequences like \r\n in the configured stop sequence
+func (r ReaderConfig) StopSequenceBytes() ([]byte, error) {
+	// a config file can also hold the raw bytes themselves
+	if !strings.Contains(r.StopSequence, `\`) {
+		return []byte(r.StopSequence), nil
+	}
+
+	unquoted, err := strconv.Unquote(`"` + r.StopSequence + `"`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stop sequence %q: %v", r.StopSequence, err)
+	}
+
+	return []byte(unquoted), nil
+}
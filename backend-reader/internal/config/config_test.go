@@ -0,0 +1,61 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("HERMES_DETECT_LOSS", "true")
+	t.Setenv("HERMES_REPLAY_SPEED", "2.5")
+	t.Setenv("HERMES_RECORD_MAX_BYTES", "1048576")
+	t.Setenv("HERMES_BATCH_INTERVAL", "50ms")
+	t.Setenv("HERMES_CORES", "4")
+
+	cfg, err := Load([]string{"-cores", "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cfg.DetectPacketLoss {
+		t.Errorf("DetectPacketLoss = false, want true")
+	}
+	if cfg.ReplaySpeed != 2.5 {
+		t.Errorf("ReplaySpeed = %v, want 2.5", cfg.ReplaySpeed)
+	}
+	if cfg.RecordMaxBytes != 1048576 {
+		t.Errorf("RecordMaxBytes = %d, want 1048576", cfg.RecordMaxBytes)
+	}
+	if cfg.BatchInterval.Duration != 50*time.Millisecond {
+		t.Errorf("BatchInterval = %v, want 50ms", cfg.BatchInterval.Duration)
+	}
+	// flags win over the environment
+	if cfg.Cores != 2 {
+		t.Errorf("Cores = %d, want the flag's 2", cfg.Cores)
+	}
+}
+
+func TestLoadEnvErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"HERMES_DETECT_LOSS", "sometimes", "true or false"},
+		{"HERMES_SNAPSHOT_WINDOW", "10", "duration"},
+		{"HERMES_ANOMALY_THRESHOLD", "high", "number"},
+		{"HERMES_SERIAL", "/dev/ttyUSB0", "unknown environment variable"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(test.name, test.value)
+
+			_, err := Load(nil)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Load with %s=%s returned %v, want an error about %q", test.name, test.value, err, test.want)
+			}
+		})
+	}
+}
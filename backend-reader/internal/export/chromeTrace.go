@@ -0,0 +1,222 @@
+package export
+
+import (
+	"RP-UCLA/backend-reader/internal/processing"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Chrome Trace Event Format, see
+// https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+const (
+	PHASE_COMPLETE = "X"
+	PHASE_INSTANT  = "i"
+	PHASE_METADATA = "M"
+
+	INSTANT_SCOPE_THREAD  = "t"
+	INSTANT_SCOPE_PROCESS = "p"
+)
+
+type ChromeTraceEvent struct {
+	Name  string                 `json:"name"`
+	Cat   string                 `json:"cat,omitempty"`
+	Phase string                 `json:"ph"`
+	Ts    int64                  `json:"ts"`
+	Dur   *int64                 `json:"dur,omitempty"`
+	Pid   int                    `json:"pid"`
+	Tid   uint32                 `json:"tid"`
+	Scope string                 `json:"s,omitempty"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+type ChromeTrace struct {
+	TraceEvents     []ChromeTraceEvent `json:"traceEvents"`
+	DisplayTimeUnit string             `json:"displayTimeUnit"`
+}
+
+// ChromeTraceCollector gathers completed calls, panics and restarts coming out of a Processor,
+// every device becomes its own process and every core its own thread in the exported trace
+type ChromeTraceCollector struct {
+	events  []ChromeTraceEvent
+	devices map[string]int
+	cores   map[string]map[uint32]bool
+}
+
+func NewChromeTraceCollector() *ChromeTraceCollector {
+	return &ChromeTraceCollector{
+		events:  make([]ChromeTraceEvent, 0),
+		devices: make(map[string]int),
+		cores:   make(map[string]map[uint32]bool),
+	}
+}
+
+func (c *ChromeTraceCollector) Broadcast(data interface{}) {
+	switch entry := data.(type) {
+	case *processing.FormattedCompletedFunctionCall:
+		c.addCompletedCall(entry)
+	case processing.FormattedTraceFunctionPanicEntry:
+		c.addPanic(&entry)
+	case processing.FormattedTraceFunctionRestartEntry:
+		c.addRestart(&entry)
+	}
+}
+
+func (c *ChromeTraceCollector) addCompletedCall(call *processing.FormattedCompletedFunctionCall) {
+	startTime, err := strconv.ParseInt(call.StartTime, 10, 64)
+	if err != nil {
+		return
+	}
+	endTime, err := strconv.ParseInt(call.EndTime, 10, 64)
+	if err != nil {
+		return
+	}
+	duration := endTime - startTime
+
+	c.events = append(c.events, ChromeTraceEvent{
+		Name:  processing.TrimCString(call.FuncName),
+		Cat:   "function",
+		Phase: PHASE_COMPLETE,
+		Ts:    startTime,
+		Dur:   &duration,
+		Pid:   c.pidFor(call.DeviceId),
+		Tid:   c.tidFor(call.DeviceId, call.CoreId),
+		Args: map[string]interface{}{
+			"funcArgs":         call.FuncArgs[:min(int(call.ArgCount), len(call.FuncArgs))],
+			"returnVal":        call.ReturnVal,
+			"funcCallId":       call.FuncNumId,
+			"parentFunctionId": call.ParentFunctionId,
+			"depth":            call.Depth,
+		},
+	})
+}
+
+func (c *ChromeTraceCollector) addPanic(entry *processing.FormattedTraceFunctionPanicEntry) {
+	timestamp, err := strconv.ParseInt(entry.Timestamp, 10, 64)
+	if err != nil {
+		return
+	}
+
+	c.events = append(c.events, ChromeTraceEvent{
+		Name:  "PANIC",
+		Cat:   "panic",
+		Phase: PHASE_INSTANT,
+		Ts:    timestamp,
+		Pid:   c.pidFor(entry.DeviceId),
+		Tid:   c.tidFor(entry.DeviceId, entry.CoreId),
+		Scope: INSTANT_SCOPE_THREAD,
+		Args: map[string]interface{}{
+			"faultingPC":      fmt.Sprintf("0x%08x", entry.FaultingPC),
+			"exceptionReason": processing.TrimCString(entry.ExceptionReason),
+		},
+	})
+}
+
+func (c *ChromeTraceCollector) addRestart(entry *processing.FormattedTraceFunctionRestartEntry) {
+	timestamp, err := strconv.ParseInt(entry.Timestamp, 10, 64)
+	if err != nil {
+		return
+	}
+
+	c.events = append(c.events, ChromeTraceEvent{
+		Name:  "RESTART",
+		Cat:   "restart",
+		Phase: PHASE_INSTANT,
+		Ts:    timestamp,
+		Pid:   c.pidFor(entry.DeviceId),
+		Tid:   c.tidFor(entry.DeviceId, entry.CoreId),
+		Scope: INSTANT_SCOPE_PROCESS,
+		Args: map[string]interface{}{
+			"restartReason": entry.RestartReason,
+		},
+	})
+}
+
+func (c *ChromeTraceCollector) pidFor(deviceId string) int {
+	if pid, ok := c.devices[deviceId]; ok {
+		return pid
+	}
+
+	// pid 0 tends to be hidden by some viewers, so start from 1
+	pid := len(c.devices) + 1
+	c.devices[deviceId] = pid
+	c.cores[deviceId] = make(map[uint32]bool)
+
+	return pid
+}
+
+func (c *ChromeTraceCollector) tidFor(deviceId string, coreId uint32) uint32 {
+	c.pidFor(deviceId)
+	c.cores[deviceId][coreId] = true
+
+	return coreId
+}
+
+// Trace builds the final trace, with timestamps made relative to the first event
+func (c *ChromeTraceCollector) Trace() ChromeTrace {
+	events := make([]ChromeTraceEvent, 0, len(c.events) + len(c.devices) * 3)
+
+	deviceIds := make([]string, 0, len(c.devices))
+	for deviceId := range c.devices {
+		deviceIds = append(deviceIds, deviceId)
+	}
+	sort.Strings(deviceIds)
+
+	for _, deviceId := range deviceIds {
+		pid := c.devices[deviceId]
+		events = append(events, ChromeTraceEvent{
+			Name:  "process_name",
+			Phase: PHASE_METADATA,
+			Pid:   pid,
+			Args:  map[string]interface{}{"name": deviceId},
+		})
+
+		coreIds := make([]uint32, 0, len(c.cores[deviceId]))
+		for coreId := range c.cores[deviceId] {
+			coreIds = append(coreIds, coreId)
+		}
+		sort.Slice(coreIds, func(i, j int) bool { return coreIds[i] < coreIds[j] })
+
+		for _, coreId := range coreIds {
+			events = append(events, ChromeTraceEvent{
+				Name:  "thread_name",
+				Phase: PHASE_METADATA,
+				Pid:   pid,
+				Tid:   coreId,
+				Args:  map[string]interface{}{"name": fmt.Sprintf("Core %d", coreId)},
+			})
+		}
+	}
+
+	timed := make([]ChromeTraceEvent, len(c.events))
+	copy(timed, c.events)
+	sort.SliceStable(timed, func(i, j int) bool { return timed[i].Ts < timed[j].Ts })
+
+	if len(timed) > 0 {
+		origin := timed[0].Ts
+		for idx := range timed {
+			timed[idx].Ts -= origin
+		}
+	}
+
+	return ChromeTrace{
+		TraceEvents:     append(events, timed...),
+		DisplayTimeUnit: "ms",
+	}
+}
+
+func (c *ChromeTraceCollector) Write(w io.Writer) error {
+	return json.NewEncoder(w).Encode(c.Trace())
+}
+
+// ExportChromeTrace processes a whole session file and writes it out as a Chrome trace
+func ExportChromeTrace(sessionPath string, coreCount uint32, w io.Writer) error {
+	collector := NewChromeTraceCollector()
+	if _, err := processing.ProcessSession(sessionPath, coreCount, collector); err != nil {
+		return err
+	}
+
+	return collector.Write(w)
+}
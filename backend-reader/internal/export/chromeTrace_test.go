@@ -0,0 +1,100 @@
+package export
+
+import (
+	"RP-UCLA/backend-reader/internal/processing"
+	"RP-UCLA/backend-reader/internal/recording"
+	tracereader "RP-UCLA/backend-reader/internal/traceReader"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// recordSession writes an ENTER / EXIT pair on every core into a session file and returns its path
+func recordSession(t *testing.T, coreCount uint32) string {
+	dir := t.TempDir()
+	recorder := recording.NewRecorder(dir, 0, 0)
+	if err := recorder.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	traceId := uint32(0)
+	record := func(entry interface{}) {
+		buf := bytes.Buffer{}
+		if err := binary.Write(&buf, binary.LittleEndian, entry); err != nil {
+			t.Fatal(err)
+		}
+
+		packet := tracereader.RawPacket{Source: "board", ReceivedAt: time.Now()}
+		copy(packet.Data[:], buf.Bytes())
+		if err := recorder.Record(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for coreId := uint32(0); coreId < coreCount; coreId++ {
+		funcNumId := coreId + 1
+		header := func(traceType uint32, timestamp uint32) processing.TraceFunctionGeneralEntry {
+			traceId++
+			return processing.TraceFunctionGeneralEntry{TraceType: traceType, CoreId: coreId, Timestamp: timestamp, TraceId: traceId, FuncNumId: funcNumId}
+		}
+
+		enter := processing.TraceFunctionEnterEntry{TraceFunctionGeneralEntry: header(processing.ENTER, 100)}
+		copy(enter.FuncName[:], "loop")
+		record(enter)
+
+		exit := processing.TraceFunctionExitEntry{TraceFunctionGeneralEntry: header(processing.EXIT, 200)}
+		copy(exit.FuncName[:], "loop")
+		record(exit)
+	}
+
+	if err := recorder.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := filepath.Glob(filepath.Join(dir, "*"+recording.FILE_EXTENSION))
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("found sessions %v (%v), want exactly one", sessions, err)
+	}
+
+	return sessions[0]
+}
+
+// exportedCores lists the threads that completed calls ended up on
+func exportedCores(t *testing.T, sessionPath string, coreCount uint32) []uint32 {
+	buf := bytes.Buffer{}
+	if err := ExportChromeTrace(sessionPath, coreCount, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	trace := ChromeTrace{}
+	if err := json.Unmarshal(buf.Bytes(), &trace); err != nil {
+		t.Fatal(err)
+	}
+
+	cores := make([]uint32, 0)
+	for _, event := range trace.TraceEvents {
+		if event.Phase == PHASE_COMPLETE {
+			cores = append(cores, event.Tid)
+		}
+	}
+	slices.Sort(cores)
+
+	return cores
+}
+
+func TestExportChromeTraceMultiCore(t *testing.T) {
+	sessionPath := recordSession(t, 4)
+
+	if cores := exportedCores(t, sessionPath, 4); !slices.Equal(cores, []uint32{0, 1, 2, 3}) {
+		t.Errorf("exported calls on cores %v, want one on each of [0 1 2 3]", cores)
+	}
+
+	// calls from cores past the configured count are dropped rather than exported on a made up thread
+	if cores := exportedCores(t, sessionPath, 2); !slices.Equal(cores, []uint32{0, 1}) {
+		t.Errorf("exported calls on cores %v with a core count of 2, want [0 1]", cores)
+	}
+}
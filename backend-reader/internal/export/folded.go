@@ -0,0 +1,21 @@
+package export
+
+import (
+	"RP-UCLA/backend-reader/internal/processing"
+	"io"
+)
+
+// nothing needs collecting, the processor aggregates the folded stacks itself
+type discardBroadcaster struct{}
+
+func (discardBroadcaster) Broadcast(data interface{}) {}
+
+// ExportFoldedStacks processes a whole session file and writes its call paths in folded stack format
+func ExportFoldedStacks(sessionPath string, coreCount uint32, w io.Writer, filter processing.FoldedStackFilter) error {
+	processor, err := processing.ProcessSession(sessionPath, coreCount, discardBroadcaster{})
+	if err != nil {
+		return err
+	}
+
+	return processor.WriteFoldedStacks(w, filter)
+}
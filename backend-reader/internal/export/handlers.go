@@ -0,0 +1,63 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Handler serves exports of the session files sitting in the recording directory
+type Handler struct {
+	SessionDir	string
+	// number of cores on the chips the sessions were recorded from
+	CoreCount	uint32
+}
+
+func NewHandler(sessionDir string, coreCount uint32) *Handler {
+	return &Handler{
+		SessionDir: sessionDir,
+		CoreCount: coreCount,
+	}
+}
+
+// HandleChromeTrace serves /export/chrome?session=session-....hrec
+func (h *Handler) HandleChromeTrace(w http.ResponseWriter, req *http.Request) {
+	path, err := h.sessionPath(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// build the whole trace first so a half processed session never gets served as a valid file
+	buf := bytes.Buffer{}
+	if err := ExportChromeTrace(path, h.CoreCount, &buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ".json"))
+	w.Write(buf.Bytes())
+}
+
+// sessionPath only accepts bare file names, so requests cannot reach outside the session directory
+func (h *Handler) sessionPath(req *http.Request) (string, error) {
+	name := req.URL.Query().Get("session")
+	if name == "" {
+		return "", fmt.Errorf("pass the session file name with ?session=")
+	}
+
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid session name %q", name)
+	}
+
+	path := filepath.Join(h.SessionDir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("no session named %q", name)
+	}
+
+	return path, nil
+}
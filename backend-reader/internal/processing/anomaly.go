@@ -0,0 +1,220 @@
+package processing
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// modified z-scores above this are flagged, the usual cut-off from Iglewicz and Hoaglin
+	DEFAULT_ANOMALY_THRESHOLD = 3.5
+	// scales the MAD so it estimates the standard deviation of normally distributed run times
+	MAD_TO_STDDEV = 1.4826
+	// run times are only measured to the microsecond, which also keeps perfectly steady functions from dividing by 0
+	MIN_MAD = 1.0
+	// later samples are dropped once a function has this many, plenty for a stable median
+	MAX_BASELINE_SAMPLES = 10000
+)
+
+// FunctionBaseline is the normal run time of one function, in microseconds
+type FunctionBaseline struct {
+	FuncName	string		`json:"funcName"`
+	Samples		int			`json:"samples"`
+	Median		float64		`json:"median"`
+	MAD			float64		`json:"mad"`
+}
+
+// Baseline holds the normal run time of every function, calls are scored by how far they sit from it
+type Baseline struct {
+	Functions	map[string]FunctionBaseline
+}
+
+// baselineBuilder collects run times until there are enough to build a Baseline from
+type baselineBuilder struct {
+	samples		map[string][]float64
+	firstEnd	int64
+}
+
+func newBaselineBuilder() *baselineBuilder {
+	return &baselineBuilder{
+		samples: make(map[string][]float64),
+	}
+}
+
+func (b *baselineBuilder) add(record *FormattedCompletedFunctionCall) {
+	funcName := TrimCString(record.FuncName)
+	if len(b.samples[funcName]) >= MAX_BASELINE_SAMPLES {
+		return
+	}
+
+	b.samples[funcName] = append(b.samples[funcName], float64(record.RunTime()))
+}
+
+func (b *baselineBuilder) build() *Baseline {
+	baseline := &Baseline{
+		Functions: make(map[string]FunctionBaseline, len(b.samples)),
+	}
+
+	for funcName, samples := range b.samples {
+		median := medianOf(samples)
+
+		deviations := make([]float64, len(samples))
+		for idx, sample := range samples {
+			deviations[idx] = math.Abs(sample - median)
+		}
+
+		baseline.Functions[funcName] = FunctionBaseline{
+			FuncName: funcName,
+			Samples: len(samples),
+			Median: median,
+			MAD: medianOf(deviations),
+		}
+	}
+
+	return baseline
+}
+
+// medianOf sorts values in place
+func medianOf(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	slices.Sort(values)
+	middle := len(values) / 2
+	if len(values) % 2 == 1 {
+		return values[middle]
+	}
+
+	return (values[middle - 1] + values[middle]) / 2
+}
+
+// Score is the modified z-score of a run time, positive when slower than normal and negative when faster.
+// ok is false for functions the baseline has never seen
+func (b *Baseline) Score(funcName string, runTime int64) (float64, bool) {
+	function, ok := b.Functions[funcName]
+	if !ok {
+		return 0, false
+	}
+
+	return (float64(runTime) - function.Median) / (MAD_TO_STDDEV * max(function.MAD, MIN_MAD)), true
+}
+
+// Sorted lists every function in the baseline by name
+func (b *Baseline) Sorted() []FunctionBaseline {
+	functions := make([]FunctionBaseline, 0, len(b.Functions))
+	for _, function := range b.Functions {
+		functions = append(functions, function)
+	}
+	sort.Slice(functions, func(i, j int) bool { return functions[i].FuncName < functions[j].FuncName })
+
+	return functions
+}
+
+// baselineCollector takes every completed call of a reference session, apart from truncated ones
+type baselineCollector struct {
+	builder		*baselineBuilder
+}
+
+func (c *baselineCollector) Broadcast(data interface{}) {
+	if record, ok := data.(*FormattedCompletedFunctionCall); ok && !record.Truncated {
+		c.builder.add(record)
+	}
+}
+
+// BuildBaseline learns what normal run times look like from a reference recording, every device in it is pooled together
+func BuildBaseline(sessionPath string, coreCount uint32) (*Baseline, error) {
+	collector := &baselineCollector{builder: newBaselineBuilder()}
+	if _, err := ProcessSession(sessionPath, coreCount, collector); err != nil {
+		return nil, err
+	}
+
+	return collector.builder.build(), nil
+}
+
+// anomalyState is the per device side of anomaly detection, used when no reference baseline was given
+type anomalyState struct {
+	mu			sync.Mutex
+	builder		*baselineBuilder
+	learned		*Baseline
+}
+
+// SetBaseline scores every device against a reference baseline instead of learning one per device
+func (p *Processor) SetBaseline(baseline *Baseline) {
+	p.baselineMu.Lock()
+	defer p.baselineMu.Unlock()
+
+	p.baseline = baseline
+}
+
+// baselineFor returns the baseline a device is scored against, nil while it is still being learnt
+func (p *Processor) baselineFor(d *deviceState) *Baseline {
+	p.baselineMu.RLock()
+	baseline := p.baseline
+	p.baselineMu.RUnlock()
+
+	if baseline != nil {
+		return baseline
+	}
+
+	d.anomaly.mu.Lock()
+	defer d.anomaly.mu.Unlock()
+
+	return d.anomaly.learned
+}
+
+// scoreAnomaly tags a completed call with how unusual its run time is. without a reference baseline the first
+// AnomalyLearnFor of every device's calls are used as its baseline, and nothing is scored until then
+func (p *Processor) scoreAnomaly(d *deviceState, record *FormattedCompletedFunctionCall) {
+	// the end of a truncated call is only a guess
+	if record.Truncated {
+		return
+	}
+
+	if baseline := p.baselineFor(d); baseline != nil {
+		score, ok := baseline.Score(TrimCString(record.FuncName), record.RunTime())
+		if !ok {
+			return
+		}
+
+		record.AnomalyScore = math.Round(score * 100) / 100
+		record.Anomalous = math.Abs(score) > p.AnomalyThreshold
+		return
+	}
+
+	if p.AnomalyLearnFor <= 0 {
+		return
+	}
+
+	endTime, _ := strconv.ParseInt(record.EndTime, 10, 64)
+
+	d.anomaly.mu.Lock()
+	defer d.anomaly.mu.Unlock()
+
+	builder := d.anomaly.builder
+	if builder.firstEnd == 0 {
+		builder.firstEnd = endTime
+	}
+	builder.add(record)
+
+	if time.Duration(endTime - builder.firstEnd) * time.Microsecond >= p.AnomalyLearnFor {
+		d.anomaly.learned = builder.build()
+		d.anomaly.builder = newBaselineBuilder()
+	}
+}
+
+// Baselines returns the baseline every device is being scored against, devices still learning are left out
+func (p *Processor) Baselines() map[string][]FunctionBaseline {
+	baselines := make(map[string][]FunctionBaseline)
+	for _, d := range p.deviceSnapshot() {
+		if baseline := p.baselineFor(d); baseline != nil {
+			baselines[d.deviceId] = baseline.Sorted()
+		}
+	}
+
+	return baselines
+}
@@ -0,0 +1,116 @@
+package processing
+
+import (
+	"sort"
+	"strings"
+)
+
+// CallPathStats is the same call counted separately for every chain of callers that led to it,
+// so i2cRead under readBaro and i2cRead under readIMU stay apart. times are in microseconds
+type CallPathStats struct {
+	CallPath		string		`json:"callPath"`
+	FuncName		string		`json:"funcName"`
+	CallsMade		int64		`json:"callsMade"`
+	TotalRunTime	int64		`json:"totalRunTime"`
+	TotalSelfTime	int64		`json:"totalSelfTime"`
+	MinRunTime		int64		`json:"minRunTime"`
+	MaxRunTime		int64		`json:"maxRunTime"`
+	AverageRunTime	float64		`json:"averageRunTime"`
+}
+
+// CallGraphEdge counts calls from one function straight into another, no matter how either was reached
+type CallGraphEdge struct {
+	Caller			string		`json:"caller"`
+	Callee			string		`json:"callee"`
+	CallsMade		int64		`json:"callsMade"`
+	// time the callee spent running when called from this caller
+	TotalRunTime	int64		`json:"totalRunTime"`
+	MaxRunTime		int64		`json:"maxRunTime"`
+	AverageRunTime	float64		`json:"averageRunTime"`
+}
+
+type CallGraphStats struct {
+	Paths			[]CallPathStats		`json:"paths"`
+	Edges			[]CallGraphEdge		`json:"edges"`
+}
+
+type callGraphEdgeKey struct {
+	caller		string
+	callee		string
+}
+
+// addCallPath assumes the lock is held, callPath is the "loop;readSensors;i2cRead" path from deviceState.callPath
+func (s *StatTracker) addCallPath(callPath string, runTime int64, selfTime int64) {
+	frames := strings.Split(callPath, ";")
+	callee := frames[len(frames) - 1]
+
+	if record, ok := s.pathStats[callPath]; ok {
+		record.CallsMade++
+		record.TotalRunTime += runTime
+		record.TotalSelfTime += selfTime
+		record.MinRunTime = min(record.MinRunTime, runTime)
+		record.MaxRunTime = max(record.MaxRunTime, runTime)
+	} else {
+		s.pathStats[callPath] = &CallPathStats{
+			CallPath: callPath,
+			FuncName: callee,
+			CallsMade: 1,
+			TotalRunTime: runTime,
+			TotalSelfTime: selfTime,
+			MinRunTime: runTime,
+			MaxRunTime: runTime,
+		}
+	}
+
+	// roots have no caller and so no edge
+	if len(frames) < 2 {
+		return
+	}
+
+	key := callGraphEdgeKey{caller: frames[len(frames) - 2], callee: callee}
+	if edge, ok := s.edges[key]; ok {
+		edge.CallsMade++
+		edge.TotalRunTime += runTime
+		edge.MaxRunTime = max(edge.MaxRunTime, runTime)
+	} else {
+		s.edges[key] = &CallGraphEdge{
+			Caller: key.caller,
+			Callee: key.callee,
+			CallsMade: 1,
+			TotalRunTime: runTime,
+			MaxRunTime: runTime,
+		}
+	}
+}
+
+// GetCallGraph copies out the per path stats and caller / callee edges of the current boot
+func (s *StatTracker) GetCallGraph() CallGraphStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	graph := CallGraphStats{
+		Paths: make([]CallPathStats, 0, len(s.pathStats)),
+		Edges: make([]CallGraphEdge, 0, len(s.edges)),
+	}
+
+	for _, record := range s.pathStats {
+		path := *record
+		path.AverageRunTime = float64(path.TotalRunTime) / float64(path.CallsMade)
+		graph.Paths = append(graph.Paths, path)
+	}
+	sort.Slice(graph.Paths, func(i, j int) bool { return graph.Paths[i].CallPath < graph.Paths[j].CallPath })
+
+	for _, record := range s.edges {
+		edge := *record
+		edge.AverageRunTime = float64(edge.TotalRunTime) / float64(edge.CallsMade)
+		graph.Edges = append(graph.Edges, edge)
+	}
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].Caller != graph.Edges[j].Caller {
+			return graph.Edges[i].Caller < graph.Edges[j].Caller
+		}
+		return graph.Edges[i].Callee < graph.Edges[j].Callee
+	})
+
+	return graph
+}
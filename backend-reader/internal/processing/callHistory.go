@@ -0,0 +1,260 @@
+package processing
+
+import (
+	"path"
+	"strconv"
+	"sync"
+	"unsafe"
+)
+
+const (
+	// completed calls kept per device, about a minute of a busy 100Hz loop
+	DEFAULT_CALL_HISTORY_SIZE = 100000
+	// rough upper bound on the memory those calls take up per device
+	DEFAULT_CALL_HISTORY_BYTES = 64 << 20
+	// matches returned by a query that doesn't set a limit
+	DEFAULT_CALL_QUERY_LIMIT = 100
+)
+
+type historyEntry struct {
+	record		*FormattedCompletedFunctionCall
+	// FuncNumIds start over when the board restarts, so calls are only unique within a boot
+	boot		uint32
+	startTime	int64
+	endTime		int64
+	size		int64
+}
+
+type historyKey struct {
+	boot		uint32
+	funcNumId	uint32
+}
+
+// callHistory keeps the most recently completed calls of a device, bounded by both count and estimated memory.
+// completed calls are never changed again once they have been broadcast, so the records are shared rather than copied
+type callHistory struct {
+	mu			sync.Mutex
+	maxCalls	int
+	maxBytes	int64
+
+	// oldest first, entries before start have been evicted and are compacted away every so often
+	entries		[]historyEntry
+	start		int
+	bytes		int64
+	// used to find the children of a call when building its subtree
+	byId		map[historyKey]historyEntry
+}
+
+// CallQuery picks calls out of the history, zero values match everything
+type CallQuery struct {
+	// microsecond timestamps, calls that overlap [From, To] match
+	From			int64
+	To				int64
+	CoreId			*uint32
+	// shell style pattern such as read* matched against function names
+	FuncPattern		string
+	// minimum run time in microseconds
+	MinDuration		int64
+	FuncNumId		*uint32
+	Limit			int
+}
+
+// CallTree is a completed call along with every call it made
+type CallTree struct {
+	*FormattedCompletedFunctionCall
+	Children	[]*CallTree		`json:"children"`
+}
+
+// CallHistoryStats is how full the history of a device is
+type CallHistoryStats struct {
+	Calls		int		`json:"calls"`
+	Bytes		int64	`json:"bytes"`
+	MaxCalls	int		`json:"maxCalls"`
+	MaxBytes	int64	`json:"maxBytes"`
+	// end times of the oldest and newest call kept, empty while there are none
+	OldestEnd	string	`json:"oldestEnd"`
+	LatestEnd	string	`json:"latestEnd"`
+}
+
+func newCallHistory(maxCalls int, maxBytes int64) *callHistory {
+	return &callHistory{
+		maxCalls: max(maxCalls, 1),
+		maxBytes: maxBytes,
+		byId: make(map[historyKey]historyEntry),
+	}
+}
+
+// estimateCallSize is roughly what a completed call costs to keep around, strings and boxed values included
+func estimateCallSize(record *FormattedCompletedFunctionCall) int64 {
+	size := int64(unsafe.Sizeof(*record))
+	size += int64(len(record.DeviceId) + len(record.Timestamp) + len(record.FuncName) + len(record.PacketId))
+	size += int64(len(record.StartTime) + len(record.EndTime) + len(record.TaskName))
+	size += int64(cap(record.ChildFunctionIds)) * int64(unsafe.Sizeof(uint32(0)))
+	// the four arguments and the return value are boxed
+	size += 5 * 16
+
+	return size
+}
+
+func (h *callHistory) add(record *FormattedCompletedFunctionCall, boot uint32) {
+	startTime, _ := strconv.ParseInt(record.StartTime, 10, 64)
+	endTime, _ := strconv.ParseInt(record.EndTime, 10, 64)
+	entry := historyEntry{
+		record: record,
+		boot: boot,
+		startTime: startTime,
+		endTime: endTime,
+		size: estimateCallSize(record),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries = append(h.entries, entry)
+	h.bytes += entry.size
+	h.byId[historyKey{boot, record.FuncNumId}] = entry
+
+	// always keep the newest call, even if it is bigger than the memory limit on its own
+	for h.len() > 1 && (h.len() > h.maxCalls || (h.maxBytes > 0 && h.bytes > h.maxBytes)) {
+		h.evictOldest()
+	}
+
+	if h.start > len(h.entries) / 2 && h.start >= 1024 {
+		h.entries = append([]historyEntry(nil), h.entries[h.start:]...)
+		h.start = 0
+	}
+}
+
+// len assumes the lock is held
+func (h *callHistory) len() int {
+	return len(h.entries) - h.start
+}
+
+// evictOldest assumes the lock is held
+func (h *callHistory) evictOldest() {
+	oldest := h.entries[h.start]
+	h.entries[h.start] = historyEntry{}
+	h.start++
+	h.bytes -= oldest.size
+
+	// the ID may already belong to a newer call from a later boot
+	key := historyKey{oldest.boot, oldest.record.FuncNumId}
+	if current, ok := h.byId[key]; ok && current.record == oldest.record {
+		delete(h.byId, key)
+	}
+}
+
+// latestEnd is the end time of the last call added, 0 while the history is empty
+func (h *callHistory) latestEnd() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.len() == 0 {
+		return 0
+	}
+
+	return h.entries[len(h.entries) - 1].endTime
+}
+
+// since returns the calls that ended at or after endTime, oldest first
+func (h *callHistory) since(endTime int64) []*FormattedCompletedFunctionCall {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	calls := make([]*FormattedCompletedFunctionCall, 0)
+	for _, entry := range h.entries[h.start:] {
+		if entry.endTime >= endTime {
+			calls = append(calls, entry.record)
+		}
+	}
+
+	return calls
+}
+
+func (q *CallQuery) matches(entry *historyEntry) bool {
+	if q.From != 0 && entry.endTime < q.From {
+		return false
+	}
+
+	if q.To != 0 && entry.startTime > q.To {
+		return false
+	}
+
+	if q.CoreId != nil && entry.record.CoreId != *q.CoreId {
+		return false
+	}
+
+	if q.FuncNumId != nil && entry.record.FuncNumId != *q.FuncNumId {
+		return false
+	}
+
+	if q.MinDuration > 0 && entry.endTime - entry.startTime < q.MinDuration {
+		return false
+	}
+
+	if q.FuncPattern != "" {
+		if matched, _ := path.Match(q.FuncPattern, TrimCString(entry.record.FuncName)); !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// query returns the subtrees of the newest calls that match, newest first
+func (h *callHistory) query(q CallQuery) []*CallTree {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DEFAULT_CALL_QUERY_LIMIT
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	trees := make([]*CallTree, 0)
+	for idx := len(h.entries) - 1; idx >= h.start && len(trees) < limit; idx-- {
+		if q.matches(&h.entries[idx]) {
+			trees = append(trees, h.subtree(h.entries[idx]))
+		}
+	}
+
+	return trees
+}
+
+// subtree assumes the lock is held. children that have already been evicted are left out
+func (h *callHistory) subtree(entry historyEntry) *CallTree {
+	tree := &CallTree{
+		FormattedCompletedFunctionCall: entry.record,
+		Children: make([]*CallTree, 0, len(entry.record.ChildFunctionIds)),
+	}
+
+	for _, childId := range entry.record.ChildFunctionIds {
+		child, ok := h.byId[historyKey{entry.boot, childId}]
+		// the depth check keeps a corrupted stream that reused an ID from looping forever
+		if !ok || child.record.Depth != entry.record.Depth + 1 {
+			continue
+		}
+
+		tree.Children = append(tree.Children, h.subtree(child))
+	}
+
+	return tree
+}
+
+func (h *callHistory) stats() CallHistoryStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := CallHistoryStats{
+		Calls: h.len(),
+		Bytes: h.bytes,
+		MaxCalls: h.maxCalls,
+		MaxBytes: h.maxBytes,
+	}
+	if h.len() > 0 {
+		stats.OldestEnd = strconv.FormatInt(h.entries[h.start].endTime, 10)
+		stats.LatestEnd = strconv.FormatInt(h.entries[len(h.entries) - 1].endTime, 10)
+	}
+
+	return stats
+}
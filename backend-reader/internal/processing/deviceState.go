@@ -0,0 +1,179 @@
+package processing
+
+import (
+	"slices"
+	"strings"
+)
+
+// callStackKey picks out one independent call tree on a device
+type callStackKey struct {
+	coreId		uint32
+	taskId		uint32
+}
+
+// activeCallKey picks out one open call. FuncNumIds are only looked up within their own call stack,
+// so the same ID showing up on another core never touches this one
+type activeCallKey struct {
+	callStackKey
+	funcNumId	uint32
+}
+
+func stackKeyOf(record *FormattedCompletedFunctionCall) callStackKey {
+	return callStackKey{coreId: record.CoreId, taskId: record.TaskId}
+}
+
+// deviceState holds everything the Processor tracks for a single board,
+// so that packets from different boards never end up in the same call tree
+type deviceState struct {
+	deviceId				string
+	timeKeeper				*TimeKeeper
+	activeFuncionCalls		map[activeCallKey]*FormattedCompletedFunctionCall
+	statTracker 			*StatTracker
+	lossDetector			lossDetector
+
+	callStacks				map[callStackKey]*[]uint32
+	// the task each core is running, cores are missing while no task switch has been seen
+	runningTasks			map[uint32]*runningTask
+	taskNames				map[uint32]string
+	// start time of the last call of every periodic function
+	periodicStarts			map[periodicKey]int64
+	anomaly					anomalyState
+	// cores we have already complained about, packets from them are dropped
+	unknownCores			map[uint32]uint64
+
+	// recently completed calls, for snapshots and /calls
+	history					*callHistory
+	// kept for clients that connect later, see SnapshotEntry
+	lastRestart				*FormattedTraceFunctionRestartEntry
+	lastPanic				*FormattedTraceFunctionPanicEntry
+}
+
+func newDeviceState(deviceId string, history *callHistory, now func() int64) *deviceState {
+	return &deviceState{
+		deviceId: deviceId,
+		timeKeeper: NewTimeKeeper(now),
+		activeFuncionCalls: make(map[activeCallKey]*FormattedCompletedFunctionCall),
+		statTracker: NewStatTracker(),
+		callStacks: make(map[callStackKey]*[]uint32),
+		runningTasks: make(map[uint32]*runningTask),
+		taskNames: make(map[uint32]string),
+		periodicStarts: make(map[periodicKey]int64),
+		anomaly: anomalyState{builder: newBaselineBuilder()},
+		unknownCores: make(map[uint32]uint64),
+		history: history,
+	}
+}
+
+// currentTask is the task running on a core, or NO_TASK for firmware that does not report task switches
+func (d *deviceState) currentTask(coreId uint32) uint32 {
+	if task, ok := d.runningTasks[coreId]; ok {
+		return task.taskId
+	}
+
+	return NO_TASK
+}
+
+// callStack returns the stack for a core and task, creating it the first time it is used
+func (d *deviceState) callStack(coreId uint32, taskId uint32) *[]uint32 {
+	key := callStackKey{coreId: coreId, taskId: taskId}
+
+	if stack, ok := d.callStacks[key]; ok {
+		return stack
+	}
+
+	stack := make([]uint32, 0)
+	d.callStacks[key] = &stack
+
+	return &stack
+}
+
+// activeCall looks up an open call on one stack
+func (d *deviceState) activeCall(stackKey callStackKey, funcNumId uint32) (*FormattedCompletedFunctionCall, bool) {
+	record, ok := d.activeFuncionCalls[activeCallKey{stackKey, funcNumId}]
+	return record, ok
+}
+
+// findExitStack works out which stack an EXIT on a core belongs to. that is normally the running task's stack,
+// but a task that migrated to another core since the ENTER is still found on its old core
+func (d *deviceState) findExitStack(coreId uint32, funcNumId uint32) (callStackKey, bool) {
+	stackKey := callStackKey{coreId: coreId, taskId: d.currentTask(coreId)}
+	if _, ok := d.activeCall(stackKey, funcNumId); ok {
+		return stackKey, true
+	}
+
+	if stackKey.taskId == NO_TASK {
+		return stackKey, false
+	}
+
+	for key := range d.callStacks {
+		if key.taskId != stackKey.taskId {
+			continue
+		}
+		if _, ok := d.activeCall(key, funcNumId); ok {
+			return key, true
+		}
+	}
+
+	return stackKey, false
+}
+
+// packetStack is the stack a packet belongs to, the one an EXIT closes a call on or the running task's stack for anything else
+func (d *deviceState) packetStack(header *TraceFunctionGeneralEntry) callStackKey {
+	if header.TraceType == EXIT {
+		stackKey, _ := d.findExitStack(header.CoreId, header.FuncNumId)
+		return stackKey
+	}
+
+	return callStackKey{coreId: header.CoreId, taskId: d.currentTask(header.CoreId)}
+}
+
+// innermostFrame is the index of the highest frame on the stack with this ID, -1 if there is none
+func innermostFrame(callStack []uint32, funcNumId uint32) int {
+	for idx := len(callStack) - 1; idx >= 0; idx-- {
+		if callStack[idx] == funcNumId {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// resetCallTrees throws away every in flight call, used when the board restarts
+func (d *deviceState) resetCallTrees() {
+	d.activeFuncionCalls = make(map[activeCallKey]*FormattedCompletedFunctionCall)
+	d.callStacks = make(map[callStackKey]*[]uint32)
+	d.runningTasks = make(map[uint32]*runningTask)
+	// the first loop after a reboot is not late
+	d.periodicStarts = make(map[periodicKey]int64)
+}
+
+// callPath walks up the parents of an active call, giving something like "loop;readSensors;i2cRead"
+func (d *deviceState) callPath(record *FormattedCompletedFunctionCall) string {
+	names := []string{TrimCString(record.FuncName)}
+
+	current := record
+	// depth bounds the walk in case a corrupted stream ever produces a cycle
+	for hops := uint32(0); current.ParentFunctionId != 0 && hops < record.Depth; hops++ {
+		parent, ok := d.activeCall(stackKeyOf(current), current.ParentFunctionId)
+		if !ok {
+			break
+		}
+
+		names = append(names, TrimCString(parent.FuncName))
+		current = parent
+	}
+
+	// names were collected from the leaf upwards
+	slices.Reverse(names)
+
+	return strings.Join(names, ";")
+}
+
+// TrimCString cuts a fixed size, NUL padded string from the board down to its contents
+func TrimCString(s string) string {
+	if idx := strings.IndexByte(s, 0); idx >= 0 {
+		return s[:idx]
+	}
+
+	return s
+}
@@ -0,0 +1,66 @@
+package processing
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/rs/xid"
+)
+
+// diagnostic kinds
+const (
+	// an EXIT closed a call that still had calls open above it on the stack
+	DIAGNOSTIC_STACK_UNWOUND = "stack_unwound"
+	// an EXIT showed up for a call that was never entered
+	DIAGNOSTIC_ORPHAN_EXIT = "orphan_exit"
+	// TraceIds jumped backwards too far to be a reordered packet, loss detection starts counting again
+	DIAGNOSTIC_TRACE_ID_RESET = "trace_id_reset"
+	// a frame on the call stack no longer had an open call, or its ID was reused before it exited
+	DIAGNOSTIC_STALE_FRAME = "stale_frame"
+	// a root function started again while its previous call was still open, so its EXIT was lost
+	DIAGNOSTIC_ROOT_RESTARTED = "root_restarted"
+	// a packet came from a core beyond the configured core count
+	DIAGNOSTIC_UNKNOWN_CORE = "unknown_core"
+)
+
+// DiagnosticEntry tells the frontend that the processor had to work around something odd in the trace stream
+type DiagnosticEntry struct {
+	TraceType			uint32		`json:"traceType"`
+	DeviceId			string		`json:"deviceId"`
+	CoreId				uint32		`json:"coreId"`
+	Timestamp			string		`json:"timestamp"`
+	Kind				string		`json:"kind"`
+	Message				string		`json:"message"`
+	FuncNumId			uint32		`json:"funcCallId"`
+	AffectedFunctionIds	[]uint32	`json:"affectedFuncCallIds"`
+	PacketId			string		`json:"packetId"`
+}
+
+func (p *Processor) broadcastDiagnostic(d *deviceState, diagnostic DiagnosticEntry) {
+	diagnostic.TraceType = DIAGNOSTIC
+	diagnostic.DeviceId = d.deviceId
+	diagnostic.PacketId = xid.New().String()
+
+	if diagnostic.AffectedFunctionIds == nil {
+		diagnostic.AffectedFunctionIds = make([]uint32, 0)
+	}
+
+	p.Broadcaster.Broadcast(diagnostic)
+}
+
+// reportUnknownCore complains the first time a core shows up that the processor was not told about,
+// later packets from that core are only counted so a misconfigured board does not flood the clients
+func (p *Processor) reportUnknownCore(d *deviceState, header *TraceFunctionGeneralEntry) {
+	d.unknownCores[header.CoreId]++
+	if d.unknownCores[header.CoreId] > 1 {
+		return
+	}
+
+	p.broadcastDiagnostic(d, DiagnosticEntry{
+		CoreId: header.CoreId,
+		Timestamp: strconv.FormatInt(d.timeKeeper.GetTimestampToSend(header.Timestamp), 10),
+		Kind: DIAGNOSTIC_UNKNOWN_CORE,
+		Message: fmt.Sprintf("packet from core %d but only %d core(s) are configured, dropping everything from this core", header.CoreId, p.CoreCount),
+		FuncNumId: header.FuncNumId,
+	})
+}
@@ -0,0 +1,116 @@
+package processing
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// what flame graph tools expect, they add up the children of a frame to get its width
+	FOLDED_WEIGHT_SELF = "self"
+	// includes the time spent in children, so feeding it to a flame graph tool counts that time twice
+	FOLDED_WEIGHT_TOTAL = "total"
+)
+
+// separators inside a frame name would split it into several frames
+var foldedFrameReplacer = strings.NewReplacer(";", "_", " ", "_")
+
+type foldedStackKey struct {
+	deviceId	string
+	coreId		uint32
+	path		string
+}
+
+type foldedStackWeights struct {
+	selfTime	int64
+	totalTime	int64
+}
+
+// FoldedStackTracker aggregates completed calls into Brendan Gregg's folded stack format,
+// e.g. "loop;readSensors;i2cRead 1234", for use with flamegraph.pl or speedscope
+type FoldedStackTracker struct {
+	mu			sync.Mutex
+	stacks		map[foldedStackKey]*foldedStackWeights
+}
+
+type FoldedStackFilter struct {
+	Weight		string
+	// empty matches every device
+	DeviceId	string
+	// nil matches every core
+	CoreId		*uint32
+}
+
+func NewFoldedStackTracker() *FoldedStackTracker {
+	return &FoldedStackTracker{
+		stacks: make(map[foldedStackKey]*foldedStackWeights),
+	}
+}
+
+func (f *FoldedStackTracker) AddCall(deviceId string, coreId uint32, path string, selfTime int64, totalTime int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := foldedStackKey{deviceId: deviceId, coreId: coreId, path: path}
+	weights, ok := f.stacks[key]
+	if !ok {
+		weights = &foldedStackWeights{}
+		f.stacks[key] = weights
+	}
+
+	weights.selfTime += selfTime
+	weights.totalTime += totalTime
+}
+
+// Write outputs one "stack weight" line per unique call path, weights are in microseconds.
+// Stacks are prefixed with the device and core they ran on unless the filter already pins those down
+func (f *FoldedStackTracker) Write(w io.Writer, filter FoldedStackFilter) error {
+	if filter.Weight != FOLDED_WEIGHT_SELF && filter.Weight != FOLDED_WEIGHT_TOTAL {
+		return fmt.Errorf("unknown weight %q, expected %s or %s", filter.Weight, FOLDED_WEIGHT_SELF, FOLDED_WEIGHT_TOTAL)
+	}
+
+	f.mu.Lock()
+	lines := make([]string, 0, len(f.stacks))
+	for key, weights := range f.stacks {
+		if filter.DeviceId != "" && key.deviceId != filter.DeviceId {
+			continue
+		}
+		if filter.CoreId != nil && key.coreId != *filter.CoreId {
+			continue
+		}
+
+		weight := weights.totalTime
+		if filter.Weight == FOLDED_WEIGHT_SELF {
+			weight = weights.selfTime
+		}
+		// zero weight frames only clutter the output
+		if weight <= 0 {
+			continue
+		}
+
+		frames := make([]string, 0, 3)
+		if filter.DeviceId == "" {
+			frames = append(frames, foldedFrameReplacer.Replace(key.deviceId))
+		}
+		if filter.CoreId == nil {
+			frames = append(frames, fmt.Sprintf("core%d", key.coreId))
+		}
+		frames = append(frames, key.path)
+
+		lines = append(lines, fmt.Sprintf("%s %d", strings.Join(frames, ";"), weight))
+	}
+	f.mu.Unlock()
+
+	sort.Strings(lines)
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,287 @@
+package processing
+
+import (
+	"RP-UCLA/backend-reader/internal/symbolizer"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+)
+
+const (
+	MAX_ELF_UPLOAD_SIZE = 64 << 20
+)
+
+// HandleFoldedStacks serves /export/folded?weight=self|total&device=...&core=0
+func (p *Processor) HandleFoldedStacks(w http.ResponseWriter, req *http.Request) {
+	filter, err := parseFoldedStackFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	buf := bytes.Buffer{}
+	if err := p.WriteFoldedStacks(&buf, filter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "stacks-" + filter.Weight + ".folded"))
+	w.Write(buf.Bytes())
+}
+
+func parseFoldedStackFilter(req *http.Request) (FoldedStackFilter, error) {
+	query := req.URL.Query()
+	filter := FoldedStackFilter{
+		Weight: FOLDED_WEIGHT_SELF,
+		DeviceId: query.Get("device"),
+	}
+
+	if weight := query.Get("weight"); weight != "" {
+		filter.Weight = weight
+	}
+
+	if core := query.Get("core"); core != "" {
+		coreId, err := strconv.ParseUint(core, 10, 32)
+		if err != nil {
+			return filter, fmt.Errorf("core should be a core number, got %q", core)
+		}
+
+		parsedCore := uint32(coreId)
+		filter.CoreId = &parsedCore
+	}
+
+	return filter, nil
+}
+
+// HandleHistograms serves /stats/histogram?device=...&func=..., returning run time buckets per device and function
+func (p *Processor) HandleHistograms(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	deviceId := query.Get("device")
+	funcName := query.Get("func")
+
+	histograms := make(map[string][]FunctionHistogram)
+	for _, d := range p.deviceSnapshot() {
+		if deviceId != "" && d.deviceId != deviceId {
+			continue
+		}
+
+		histograms[d.deviceId] = d.statTracker.GetHistograms(funcName)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(histograms)
+}
+
+// HandleStatReset clears the stats of the current boot, for every device or just ?device=
+func (p *Processor) HandleStatReset(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "use POST to reset stats", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deviceId := req.URL.Query().Get("device")
+	reset := make([]string, 0)
+	for _, d := range p.deviceSnapshot() {
+		if deviceId != "" && d.deviceId != deviceId {
+			continue
+		}
+
+		d.statTracker.Reset()
+		reset = append(reset, d.deviceId)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"reset": reset,
+	})
+}
+
+// HandleStatSegments serves /stats/boots?device=..., the stats of every boot kept for each device
+func (p *Processor) HandleStatSegments(w http.ResponseWriter, req *http.Request) {
+	deviceId := req.URL.Query().Get("device")
+
+	segments := make(map[string][]StatSegment)
+	for _, d := range p.deviceSnapshot() {
+		if deviceId != "" && d.deviceId != deviceId {
+			continue
+		}
+
+		segments[d.deviceId] = d.statTracker.GetSegments(d.timeKeeper.Now())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(segments)
+}
+
+// HandleCallGraph serves /stats/callgraph?device=..., stats per call path and per caller / callee pair
+func (p *Processor) HandleCallGraph(w http.ResponseWriter, req *http.Request) {
+	deviceId := req.URL.Query().Get("device")
+
+	graphs := make(map[string]CallGraphStats)
+	for _, d := range p.deviceSnapshot() {
+		if deviceId != "" && d.deviceId != deviceId {
+			continue
+		}
+
+		graphs[d.deviceId] = d.statTracker.GetCallGraph()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(graphs)
+}
+
+// HandleBaselines serves /stats/baseline?device=..., the run times each device's calls are scored against
+func (p *Processor) HandleBaselines(w http.ResponseWriter, req *http.Request) {
+	deviceId := req.URL.Query().Get("device")
+
+	baselines := p.Baselines()
+	if deviceId != "" {
+		baselines = map[string][]FunctionBaseline{deviceId: baselines[deviceId]}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(baselines)
+}
+
+// HandleFirmwareUpload takes the raw firmware ELF as the POST body, optionally scoped to one board with ?device=
+func (p *Processor) HandleFirmwareUpload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST the firmware ELF as the request body", http.StatusMethodNotAllowed)
+		return
+	}
+
+	contents, err := io.ReadAll(http.MaxBytesReader(w, req.Body, MAX_ELF_UPLOAD_SIZE))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to read upload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	s, err := symbolizer.Parse(bytes.NewReader(contents))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to parse firmware ELF: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	deviceId := req.URL.Query().Get("device")
+	p.SetSymbolizer(deviceId, s)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"device": deviceId,
+		"bytes": len(contents),
+	})
+}
+
+// HandleCalls serves /calls?device=...&from=...&to=...&last=30s&core=0&func=read*&minDuration=2ms&id=...&limit=100,
+// the full subtree of the newest recently completed calls that match, per device and newest first.
+// from and to are microsecond timestamps, last is instead counted back from the device's newest call
+func (p *Processor) HandleCalls(w http.ResponseWriter, req *http.Request) {
+	query, last, err := parseCallQuery(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deviceId := req.URL.Query().Get("device")
+	calls := make(map[string][]*CallTree)
+	for _, d := range p.deviceSnapshot() {
+		if deviceId != "" && d.deviceId != deviceId {
+			continue
+		}
+
+		deviceQuery := query
+		if last > 0 {
+			deviceQuery.From = max(deviceQuery.From, d.history.latestEnd() - last.Microseconds())
+		}
+
+		calls[d.deviceId] = d.history.query(deviceQuery)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(calls)
+}
+
+func parseCallQuery(req *http.Request) (CallQuery, time.Duration, error) {
+	values := req.URL.Query()
+	query := CallQuery{
+		FuncPattern: values.Get("func"),
+	}
+	var last time.Duration
+
+	if _, err := path.Match(query.FuncPattern, ""); err != nil {
+		return query, last, fmt.Errorf("bad function pattern %q: %v", query.FuncPattern, err)
+	}
+
+	for name, dst := range map[string]*int64{"from": &query.From, "to": &query.To} {
+		if value := values.Get(name); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return query, last, fmt.Errorf("%s should be a timestamp in microseconds, got %q", name, value)
+			}
+			*dst = parsed
+		}
+	}
+
+	if value := values.Get("last"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return query, last, fmt.Errorf("last should be a duration such as 30s, got %q", value)
+		}
+		last = parsed
+	}
+
+	for name, dst := range map[string]**uint32{"core": &query.CoreId, "id": &query.FuncNumId} {
+		if value := values.Get(name); value != "" {
+			parsed, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return query, last, fmt.Errorf("%s should be a number, got %q", name, value)
+			}
+			parsedValue := uint32(parsed)
+			*dst = &parsedValue
+		}
+	}
+
+	// either a duration such as 2ms or a plain number of microseconds
+	if value := values.Get("minDuration"); value != "" {
+		if micros, err := strconv.ParseInt(value, 10, 64); err == nil {
+			query.MinDuration = micros
+		} else if duration, err := time.ParseDuration(value); err == nil {
+			query.MinDuration = duration.Microseconds()
+		} else {
+			return query, last, fmt.Errorf("minDuration should be a duration such as 2ms, got %q", value)
+		}
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return query, last, fmt.Errorf("limit should be a positive number, got %q", value)
+		}
+		query.Limit = limit
+	}
+
+	return query, last, nil
+}
+
+// HandleCallHistory serves /calls/status?device=..., how many calls are kept for each device and how much memory they take
+func (p *Processor) HandleCallHistory(w http.ResponseWriter, req *http.Request) {
+	deviceId := req.URL.Query().Get("device")
+
+	stats := make(map[string]CallHistoryStats)
+	for _, d := range p.deviceSnapshot() {
+		if deviceId != "" && d.deviceId != deviceId {
+			continue
+		}
+
+		stats[d.deviceId] = d.history.stats()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,136 @@
+package processing
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// values below 2^HISTOGRAM_PRECISION_BITS get a bucket each, above that every power of two is split into
+	// 2^(HISTOGRAM_PRECISION_BITS - 1) buckets. a bucket is then never wider than 1/128 of its lowest value,
+	// so reporting the top of a bucket overstates a value by under 0.8%
+	HISTOGRAM_PRECISION_BITS = 8
+	HISTOGRAM_LINEAR_BUCKETS = 1 << HISTOGRAM_PRECISION_BITS
+	HISTOGRAM_SUB_BUCKETS = HISTOGRAM_LINEAR_BUCKETS / 2
+)
+
+// HistogramBucket covers run times from Low to High inclusive, in microseconds
+type HistogramBucket struct {
+	Low			int64		`json:"low"`
+	High		int64		`json:"high"`
+	Count		uint64		`json:"count"`
+}
+
+// Histogram is an HDR style log-linear histogram of run times, it never needs more than a few thousand counters
+// no matter how long it runs since buckets widen as values grow
+type Histogram struct {
+	counts		[]uint64
+	total		uint64
+	min			int64
+	max			int64
+
+	// Welford's running mean and sum of squared differences, for the standard deviation
+	mean		float64
+	m2			float64
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{
+		counts: make([]uint64, HISTOGRAM_LINEAR_BUCKETS),
+	}
+}
+
+func histogramBucketIndex(value int64) int {
+	if value < HISTOGRAM_LINEAR_BUCKETS {
+		return int(value)
+	}
+
+	shift := bits.Len64(uint64(value)) - HISTOGRAM_PRECISION_BITS
+	// value >> shift always lands in [HISTOGRAM_SUB_BUCKETS, HISTOGRAM_LINEAR_BUCKETS)
+	return HISTOGRAM_LINEAR_BUCKETS + (shift - 1) * HISTOGRAM_SUB_BUCKETS + int(value >> shift) - HISTOGRAM_SUB_BUCKETS
+}
+
+// histogramBucketRange is the inverse of histogramBucketIndex
+func histogramBucketRange(idx int) (int64, int64) {
+	if idx < HISTOGRAM_LINEAR_BUCKETS {
+		return int64(idx), int64(idx)
+	}
+
+	shift := (idx - HISTOGRAM_LINEAR_BUCKETS) / HISTOGRAM_SUB_BUCKETS + 1
+	mantissa := int64((idx - HISTOGRAM_LINEAR_BUCKETS) % HISTOGRAM_SUB_BUCKETS + HISTOGRAM_SUB_BUCKETS)
+
+	return mantissa << shift, ((mantissa + 1) << shift) - 1
+}
+
+func (h *Histogram) Record(value int64) {
+	// clock hiccups can produce negative run times, count them as zero rather than dropping them
+	value = max(value, 0)
+
+	idx := histogramBucketIndex(value)
+	for len(h.counts) <= idx {
+		h.counts = append(h.counts, 0)
+	}
+	h.counts[idx]++
+
+	if h.total == 0 {
+		h.min = value
+		h.max = value
+	}
+	h.min = min(h.min, value)
+	h.max = max(h.max, value)
+
+	h.total++
+	delta := float64(value) - h.mean
+	h.mean += delta / float64(h.total)
+	h.m2 += delta * (float64(value) - h.mean)
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.total
+}
+
+// StdDev is the population standard deviation of everything recorded so far
+func (h *Histogram) StdDev() float64 {
+	if h.total == 0 {
+		return 0
+	}
+
+	return math.Sqrt(h.m2 / float64(h.total))
+}
+
+// Percentile returns the run time that q (0 to 1) of the recorded values are at or below,
+// reported as the top of the bucket it falls in so it never understates the latency
+func (h *Histogram) Percentile(q float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(h.total)))
+	rank = min(max(rank, 1), h.total)
+
+	seen := uint64(0)
+	for idx, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			_, high := histogramBucketRange(idx)
+			return min(high, h.max)
+		}
+	}
+
+	return h.max
+}
+
+// Buckets returns every non empty bucket in ascending order
+func (h *Histogram) Buckets() []HistogramBucket {
+	buckets := make([]HistogramBucket, 0)
+	for idx, count := range h.counts {
+		if count == 0 {
+			continue
+		}
+
+		low, high := histogramBucketRange(idx)
+		buckets = append(buckets, HistogramBucket{Low: low, High: high, Count: count})
+	}
+
+	return buckets
+}
@@ -0,0 +1,112 @@
+package processing
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	tests := []struct {
+		value		int64
+		wantLow		int64
+		wantHigh	int64
+	}{
+		{0, 0, 0},
+		{1, 1, 1},
+		{HISTOGRAM_LINEAR_BUCKETS - 1, HISTOGRAM_LINEAR_BUCKETS - 1, HISTOGRAM_LINEAR_BUCKETS - 1},
+		{HISTOGRAM_LINEAR_BUCKETS, HISTOGRAM_LINEAR_BUCKETS, HISTOGRAM_LINEAR_BUCKETS + 1},
+		{HISTOGRAM_LINEAR_BUCKETS + 1, HISTOGRAM_LINEAR_BUCKETS, HISTOGRAM_LINEAR_BUCKETS + 1},
+		{1000, 1000, 1003},
+		{1_000_000, 999_424, 1_003_519},
+	}
+
+	for _, test := range tests {
+		low, high := histogramBucketRange(histogramBucketIndex(test.value))
+		if low != test.wantLow || high != test.wantHigh {
+			t.Errorf("bucket of %d = [%d, %d], want [%d, %d]", test.value, low, high, test.wantLow, test.wantHigh)
+		}
+	}
+}
+
+func TestHistogramBucketsCoverEveryValue(t *testing.T) {
+	previousHigh := int64(-1)
+	for idx := 0; idx < HISTOGRAM_LINEAR_BUCKETS + 40 * HISTOGRAM_SUB_BUCKETS; idx++ {
+		low, high := histogramBucketRange(idx)
+		if low != previousHigh + 1 {
+			t.Fatalf("bucket %d starts at %d, the previous one ended at %d", idx, low, previousHigh)
+		}
+
+		for _, value := range []int64{low, high} {
+			if got := histogramBucketIndex(value); got != idx {
+				t.Fatalf("%d landed in bucket %d, want %d", value, got, idx)
+			}
+		}
+
+		// the top of a bucket is what percentiles report, it has to stay within 1% of anything in the bucket
+		if low > 0 && float64(high - low) / float64(low) >= 0.01 {
+			t.Fatalf("bucket %d [%d, %d] is more than 1%% wide", idx, low, high)
+		}
+
+		previousHigh = high
+	}
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	tests := []struct {
+		name		string
+		values		[]int64
+		q			float64
+		want		int64
+	}{
+		{"empty", nil, 0.5, 0},
+		{"single value", []int64{42}, 0.99, 42},
+		{"median of small values", []int64{1, 2, 3, 4, 5}, 0.5, 3},
+		{"p0 is the smallest value", []int64{5, 1, 3}, 0, 1},
+		{"p100 is the largest value", []int64{5, 1, 3}, 1, 5},
+		{"capped at the max", []int64{1000}, 0.5, 1000},
+		{"reports the top of the bucket", []int64{1000, 1002}, 0.5, 1002},
+		{"negative run times count as zero", []int64{-5, -1}, 1, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			histogram := NewHistogram()
+			for _, value := range test.values {
+				histogram.Record(value)
+			}
+
+			if got := histogram.Percentile(test.q); got != test.want {
+				t.Errorf("Percentile(%v) = %d, want %d", test.q, got, test.want)
+			}
+		})
+	}
+}
+
+func TestHistogramPercentileError(t *testing.T) {
+	histogram := NewHistogram()
+	for value := int64(1); value <= 100_000; value++ {
+		histogram.Record(value)
+	}
+
+	for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+		exact := float64(int64(math.Ceil(q * 100_000)))
+		got := float64(histogram.Percentile(q))
+		if got < exact || (got - exact) / exact >= 0.01 {
+			t.Errorf("Percentile(%v) = %v, want within 1%% above %v", q, got, exact)
+		}
+	}
+}
+
+func TestHistogramStdDev(t *testing.T) {
+	histogram := NewHistogram()
+	for _, value := range []int64{2, 4, 4, 4, 5, 5, 7, 9} {
+		histogram.Record(value)
+	}
+
+	if got := histogram.StdDev(); math.Abs(got - 2) > 1e-9 {
+		t.Errorf("StdDev() = %v, want 2", got)
+	}
+	if got := histogram.Count(); got != 8 {
+		t.Errorf("Count() = %d, want 8", got)
+	}
+}
@@ -0,0 +1,121 @@
+package processing
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/rs/xid"
+)
+
+// PacketLossEntry reports a gap in the TraceIds coming off a device
+type PacketLossEntry struct {
+	TraceType			uint32		`json:"traceType"`
+	DeviceId			string		`json:"deviceId"`
+	CoreId				uint32		`json:"coreId"`
+	Timestamp			string		`json:"timestamp"`
+	// the gap sits between these two trace IDs
+	LastTraceId			uint32		`json:"lastTraceId"`
+	ReceivedTraceId		uint32		`json:"receivedTraceId"`
+	PacketsLost			uint32		`json:"packetsLost"`
+	TotalPacketsLost	uint64		`json:"totalPacketsLost"`
+	// calls open on the stack the packet after the gap landed on, root first. their trees may be missing children or an exit
+	AffectedFunctionIds	[]uint32	`json:"affectedFuncCallIds"`
+	PacketId			string		`json:"packetId"`
+}
+
+const (
+	// TraceIds going back further than this are a new count rather than a reordered packet,
+	// e.g. a RESTART that never arrived
+	MAX_TRACE_ID_REORDER = 1024
+)
+
+// lossDetector assumes the firmware stamps every packet with a TraceId that goes up by exactly one per packet
+type lossDetector struct {
+	lastTraceId		uint32
+	seenFirst		bool
+	totalLost		uint64
+}
+
+// observe returns how many packets went missing right before traceId, and whether the count jumped
+// back so far that counting started over from traceId
+func (l *lossDetector) observe(traceId uint32) (uint32, bool) {
+	if !l.seenFirst {
+		l.seenFirst = true
+		l.lastTraceId = traceId
+		return 0, false
+	}
+
+	// unsigned subtraction handles the counter wrapping around
+	gap := traceId - l.lastTraceId
+	if gap > math.MaxInt32 {
+		// a little way back is a duplicate or a reordered packet rather than a loss
+		if l.lastTraceId - traceId <= MAX_TRACE_ID_REORDER {
+			return 0, false
+		}
+
+		l.lastTraceId = traceId
+		return 0, true
+	}
+
+	if gap == 0 {
+		return 0, false
+	}
+
+	l.lastTraceId = traceId
+	lost := gap - 1
+	l.totalLost += uint64(lost)
+
+	return lost, false
+}
+
+// reset is used after a board restart, since the firmware starts counting from scratch
+func (l *lossDetector) reset() {
+	l.seenFirst = false
+	l.lastTraceId = 0
+}
+
+func (p *Processor) checkForLoss(d *deviceState, header *TraceFunctionGeneralEntry) {
+	lastTraceId := d.lossDetector.lastTraceId
+	lost, reanchored := d.lossDetector.observe(header.TraceId)
+	if reanchored {
+		p.broadcastDiagnostic(d, DiagnosticEntry{
+			CoreId: header.CoreId,
+			Timestamp: strconv.FormatInt(d.timeKeeper.GetTimestampToSend(header.Timestamp), 10),
+			Kind: DIAGNOSTIC_TRACE_ID_RESET,
+			Message: fmt.Sprintf("TraceId went back from %d to %d, the board may have restarted without a RESTART arriving. counting again from here", lastTraceId, header.TraceId),
+			FuncNumId: header.FuncNumId,
+		})
+		return
+	}
+
+	if lost == 0 {
+		return
+	}
+
+	// the missing packets could have belonged to any stack, but the one this packet lands on is the only one known to
+	// have carried on past the gap. its open calls may be missing children or an exit, other stacks are left alone
+	stackKey := d.packetStack(header)
+	affected := make([]uint32, 0)
+	if callStack, ok := d.callStacks[stackKey]; ok {
+		for _, funcNumId := range *callStack {
+			if record, ok := d.activeCall(stackKey, funcNumId); ok {
+				record.Incomplete = true
+				affected = append(affected, funcNumId)
+			}
+		}
+	}
+
+	p.Broadcaster.Broadcast(PacketLossEntry{
+		TraceType: PACKET_LOSS,
+		DeviceId: d.deviceId,
+		CoreId: header.CoreId,
+		Timestamp: strconv.FormatInt(d.timeKeeper.GetTimestampToSend(header.Timestamp), 10),
+		LastTraceId: lastTraceId,
+		ReceivedTraceId: header.TraceId,
+		PacketsLost: lost,
+		TotalPacketsLost: d.lossDetector.totalLost,
+		AffectedFunctionIds: affected,
+		PacketId: xid.New().String(),
+	})
+}
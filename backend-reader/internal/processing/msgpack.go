@@ -0,0 +1,372 @@
+package processing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// a small MessagePack encoder (https://github.com/msgpack/msgpack/blob/master/spec.md) that follows the json tags,
+// so binary clients decode exactly the same objects as JSON clients do
+
+type msgpackField struct {
+	name		string
+	index		[]int
+	omitEmpty	bool
+}
+
+// fields of every struct type seen so far, embedded structs are flattened the same way encoding/json does
+var msgpackFieldCache sync.Map
+
+var jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+
+func encodeMsgpack(data interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+	if err := writeMsgpack(&buf, reflect.ValueOf(data)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeMsgpack(buf *bytes.Buffer, value reflect.Value) error {
+	if !value.IsValid() {
+		buf.WriteByte(0xc0)
+		return nil
+	}
+
+	// types with their own JSON encoding go through it so the shapes still match
+	if value.Type().Implements(jsonMarshalerType) {
+		if value.Kind() == reflect.Pointer && value.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		return writeMsgpackViaJSON(buf, value.Interface())
+	}
+
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if value.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		return writeMsgpack(buf, value.Elem())
+	case reflect.Bool:
+		if value.Bool() {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeMsgpackInt(buf, value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeMsgpackUint(buf, value.Uint())
+	case reflect.Float32:
+		// go through the shortest decimal form, otherwise 0.1 arrives as 0.10000000149011612 where JSON says 0.1
+		widened, _ := strconv.ParseFloat(strconv.FormatFloat(value.Float(), 'g', -1, 32), 64)
+		writeMsgpackFloat(buf, widened)
+	case reflect.Float64:
+		writeMsgpackFloat(buf, value.Float())
+	case reflect.String:
+		writeMsgpackString(buf, value.String())
+	case reflect.Slice:
+		if value.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		// encoding/json sends byte slices as base64 strings
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			return writeMsgpackViaJSON(buf, value.Interface())
+		}
+		fallthrough
+	case reflect.Array:
+		writeMsgpackHeader(buf, value.Len(), 0x90, 0xdc, 0xdd)
+		for idx := 0; idx < value.Len(); idx++ {
+			if err := writeMsgpack(buf, value.Index(idx)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if value.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		if value.Type().Key().Kind() != reflect.String {
+			return writeMsgpackViaJSON(buf, value.Interface())
+		}
+
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+		writeMsgpackHeader(buf, len(keys), 0x80, 0xde, 0xdf)
+		for _, key := range keys {
+			writeMsgpackString(buf, key.String())
+			if err := writeMsgpack(buf, value.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		return writeMsgpackStruct(buf, value)
+	default:
+		return fmt.Errorf("unable to encode %s as MessagePack", value.Type())
+	}
+
+	return nil
+}
+
+func writeMsgpackStruct(buf *bytes.Buffer, value reflect.Value) error {
+	fields := msgpackFields(value.Type())
+
+	// omitempty means the field count is only known once the fields have been looked at
+	body := bytes.Buffer{}
+	count := 0
+	for _, field := range fields {
+		fieldValue, ok := fieldByIndex(value, field.index)
+		if !ok || (field.omitEmpty && isEmptyValue(fieldValue)) {
+			continue
+		}
+
+		writeMsgpackString(&body, field.name)
+		if err := writeMsgpack(&body, fieldValue); err != nil {
+			return err
+		}
+		count++
+	}
+
+	writeMsgpackHeader(buf, count, 0x80, 0xde, 0xdf)
+	buf.Write(body.Bytes())
+
+	return nil
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex without panicking on nil embedded pointers
+func fieldByIndex(value reflect.Value, index []int) (reflect.Value, bool) {
+	for depth, idx := range index {
+		if depth > 0 && value.Kind() == reflect.Pointer {
+			if value.IsNil() {
+				return reflect.Value{}, false
+			}
+			value = value.Elem()
+		}
+		value = value.Field(idx)
+	}
+
+	return value, true
+}
+
+func msgpackFields(structType reflect.Type) []msgpackField {
+	if cached, ok := msgpackFieldCache.Load(structType); ok {
+		return cached.([]msgpackField)
+	}
+
+	fields := collectMsgpackFields(structType, nil)
+	msgpackFieldCache.Store(structType, fields)
+
+	return fields
+}
+
+func collectMsgpackFields(structType reflect.Type, parentIndex []int) []msgpackField {
+	fields := make([]msgpackField, 0, structType.NumField())
+
+	for idx := 0; idx < structType.NumField(); idx++ {
+		field := structType.Field(idx)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+		index := append(append([]int{}, parentIndex...), idx)
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			fields = append(fields, collectMsgpackFields(fieldType, index)...)
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, msgpackField{
+			name: name,
+			index: index,
+			omitEmpty: strings.Contains(options, "omitempty"),
+		})
+	}
+
+	return fields
+}
+
+func isEmptyValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return value.Len() == 0
+	case reflect.Bool:
+		return !value.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return value.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return value.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return value.IsNil()
+	}
+
+	return false
+}
+
+// writeMsgpackViaJSON handles anything with its own JSON encoding by re-encoding the decoded JSON
+func writeMsgpackViaJSON(buf *bytes.Buffer, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return err
+	}
+
+	return writeMsgpackGeneric(buf, generic)
+}
+
+// writeMsgpackGeneric writes decoded JSON, numbers have to be picked out at every level since json.Number is a string
+func writeMsgpackGeneric(buf *bytes.Buffer, generic interface{}) error {
+	switch value := generic.(type) {
+	case []interface{}:
+		writeMsgpackHeader(buf, len(value), 0x90, 0xdc, 0xdd)
+		for _, element := range value {
+			if err := writeMsgpackGeneric(buf, element); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		writeMsgpackHeader(buf, len(keys), 0x80, 0xde, 0xdf)
+		for _, key := range keys {
+			writeMsgpackString(buf, key)
+			if err := writeMsgpackGeneric(buf, value[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	number, ok := generic.(json.Number)
+	if !ok {
+		return writeMsgpack(buf, reflect.ValueOf(generic))
+	}
+
+	if asInt, err := number.Int64(); err == nil {
+		writeMsgpackInt(buf, asInt)
+		return nil
+	}
+
+	asFloat, err := number.Float64()
+	if err != nil {
+		return err
+	}
+	writeMsgpackFloat(buf, asFloat)
+
+	return nil
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, value int64) {
+	switch {
+	case value >= 0:
+		writeMsgpackUint(buf, uint64(value))
+	case value >= -32:
+		buf.WriteByte(byte(0xe0 | (value + 32)))
+	case value >= math.MinInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(int8(value)))
+	case value >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(value))
+	case value >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(value))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, value)
+	}
+}
+
+func writeMsgpackUint(buf *bytes.Buffer, value uint64) {
+	switch {
+	case value <= 0x7f:
+		buf.WriteByte(byte(value))
+	case value <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(value))
+	case value <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(value))
+	case value <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(value))
+	default:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, value)
+	}
+}
+
+func writeMsgpackFloat(buf *bytes.Buffer, value float64) {
+	buf.WriteByte(0xcb)
+	binary.Write(buf, binary.BigEndian, math.Float64bits(value))
+}
+
+func writeMsgpackString(buf *bytes.Buffer, value string) {
+	switch {
+	case len(value) < 32:
+		buf.WriteByte(byte(0xa0 | len(value)))
+	case len(value) <= math.MaxUint8:
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(len(value)))
+	case len(value) <= math.MaxUint16:
+		buf.WriteByte(0xda)
+		binary.Write(buf, binary.BigEndian, uint16(len(value)))
+	default:
+		buf.WriteByte(0xdb)
+		binary.Write(buf, binary.BigEndian, uint32(len(value)))
+	}
+	buf.WriteString(value)
+}
+
+// writeMsgpackHeader writes the length of an array or map, picking the fix, 16 bit or 32 bit form
+func writeMsgpackHeader(buf *bytes.Buffer, length int, fixPrefix byte, prefix16 byte, prefix32 byte) {
+	switch {
+	case length < 16:
+		buf.WriteByte(fixPrefix | byte(length))
+	case length <= math.MaxUint16:
+		buf.WriteByte(prefix16)
+		binary.Write(buf, binary.BigEndian, uint16(length))
+	default:
+		buf.WriteByte(prefix32)
+		binary.Write(buf, binary.BigEndian, uint32(length))
+	}
+}
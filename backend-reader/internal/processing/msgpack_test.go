@@ -0,0 +1,153 @@
+package processing
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type msgpackInner struct {
+	Depth		uint32		`json:"depth"`
+}
+
+type msgpackOuter struct {
+	msgpackInner
+	Name		string		`json:"name"`
+	Skipped		string		`json:"-"`
+	Optional	string		`json:"optional,omitempty"`
+	NoTag		bool
+	hidden		int
+}
+
+// rawJSON has its own JSON encoding, which the MessagePack encoding has to follow
+type rawJSON struct{}
+
+func (rawJSON) MarshalJSON() ([]byte, error) {
+	return []byte(`{"a":[1,-2.5]}`), nil
+}
+
+func TestEncodeMsgpack(t *testing.T) {
+	tests := []struct {
+		name	string
+		data	interface{}
+		want	[]byte
+	}{
+		{"nil", nil, []byte{0xc0}},
+		{"true", true, []byte{0xc3}},
+		{"false", false, []byte{0xc2}},
+		{"positive fixint", 5, []byte{0x05}},
+		{"uint8", uint32(200), []byte{0xcc, 200}},
+		{"uint16", 1000, []byte{0xcd, 0x03, 0xe8}},
+		{"uint32", uint32(70000), []byte{0xce, 0x00, 0x01, 0x11, 0x70}},
+		{"uint64", int64(1) << 40, []byte{0xcf, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{"negative fixint", -3, []byte{0xfd}},
+		{"int8", -100, []byte{0xd0, 0x9c}},
+		{"int16", -1000, []byte{0xd1, 0xfc, 0x18}},
+		{"int32", -100000, []byte{0xd2, 0xff, 0xfe, 0x79, 0x60}},
+		{"float64", 1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		// widened through its shortest decimal form so it matches what JSON clients see
+		{"float32", float32(0.1), []byte{0xcb, 0x3f, 0xb9, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}},
+		{"fixstr", "abc", []byte{0xa3, 'a', 'b', 'c'}},
+		{"str8", strings.Repeat("x", 40), append([]byte{0xd9, 40}, strings.Repeat("x", 40)...)},
+		{"nil slice", []uint32(nil), []byte{0xc0}},
+		{"array", []uint32{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{"fixed size array", [2]string{"a", ""}, []byte{0x92, 0xa1, 'a', 0xa0}},
+		{"interface array", [4]interface{}{1, "b", nil, false}, []byte{0x94, 0x01, 0xa1, 'b', 0xc0, 0xc2}},
+		// keys are sorted so the encoding is stable
+		{"map", map[string]int{"b": 2, "a": 1}, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}},
+		{"nil pointer", (*msgpackInner)(nil), []byte{0xc0}},
+		{"pointer", &msgpackInner{Depth: 3}, []byte{0x81, 0xa5, 'd', 'e', 'p', 't', 'h', 0x03}},
+		{
+			"struct follows json tags",
+			msgpackOuter{msgpackInner: msgpackInner{Depth: 1}, Name: "f", Skipped: "x", hidden: 4},
+			[]byte{
+				0x83,
+				0xa5, 'd', 'e', 'p', 't', 'h', 0x01,
+				0xa4, 'n', 'a', 'm', 'e', 0xa1, 'f',
+				0xa5, 'N', 'o', 'T', 'a', 'g', 0xc2,
+			},
+		},
+		{
+			"omitempty keeps set fields",
+			msgpackOuter{Optional: "o"},
+			[]byte{
+				0x84,
+				0xa5, 'd', 'e', 'p', 't', 'h', 0x00,
+				0xa4, 'n', 'a', 'm', 'e', 0xa0,
+				0xa8, 'o', 'p', 't', 'i', 'o', 'n', 'a', 'l', 0xa1, 'o',
+				0xa5, 'N', 'o', 'T', 'a', 'g', 0xc2,
+			},
+		},
+		{
+			"json marshaler",
+			rawJSON{},
+			[]byte{0x81, 0xa1, 'a', 0x92, 0x01, 0xcb, 0xc0, 0x04, 0, 0, 0, 0, 0, 0},
+		},
+		{"byte slice as base64", []byte{1, 2, 3}, []byte{0xa4, 'A', 'Q', 'I', 'D'}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := encodeMsgpack(test.data)
+			if err != nil {
+				t.Fatalf("encodeMsgpack() error = %v", err)
+			}
+
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("encodeMsgpack() = % x, want % x", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEncodeMsgpackLengths(t *testing.T) {
+	tests := []struct {
+		name		string
+		data		interface{}
+		wantPrefix	[]byte
+	}{
+		{"array16", make([]bool, 16), []byte{0xdc, 0x00, 0x10}},
+		{"array32", make([]bool, 1 << 16), []byte{0xdd, 0x00, 0x01, 0x00, 0x00}},
+		{"str16", strings.Repeat("x", 256), []byte{0xda, 0x01, 0x00}},
+		{"str32", strings.Repeat("x", 1 << 16), []byte{0xdb, 0x00, 0x01, 0x00, 0x00}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := encodeMsgpack(test.data)
+			if err != nil {
+				t.Fatalf("encodeMsgpack() error = %v", err)
+			}
+
+			if !bytes.HasPrefix(got, test.wantPrefix) {
+				t.Errorf("encodeMsgpack() starts with % x, want % x", got[:len(test.wantPrefix)], test.wantPrefix)
+			}
+		})
+	}
+}
+
+func TestEncodeMsgpackUnsupported(t *testing.T) {
+	if _, err := encodeMsgpack(make(chan int)); err == nil {
+		t.Error("encodeMsgpack() of a channel succeeded, want an error")
+	}
+}
+
+// the trace entries sent to clients should encode without falling back on anything unsupported
+func TestEncodeMsgpackTraceEntries(t *testing.T) {
+	entries := []interface{}{
+		&FormattedCompletedFunctionCall{FuncName: "loop", ChildFunctionIds: []uint32{2, 3}},
+		StatPacket{TraceType: STAT_UPDATES, StatMap: []FormattedFunctionStats{{FuncName: "loop"}}},
+		DiagnosticEntry{TraceType: DIAGNOSTIC, Kind: DIAGNOSTIC_STACK_UNWOUND},
+	}
+
+	for _, entry := range entries {
+		if _, err := encodeMsgpack(entry); err != nil {
+			t.Errorf("encodeMsgpack(%T) error = %v", entry, err)
+		}
+
+		if _, err := json.Marshal(entry); err != nil {
+			t.Errorf("json.Marshal(%T) error = %v", entry, err)
+		}
+	}
+}
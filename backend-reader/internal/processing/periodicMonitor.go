@@ -0,0 +1,127 @@
+package processing
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+const (
+	// the call ran for longer than its deadline
+	DEADLINE_OVERRUN = "overrun"
+	// the call started further from the expected period than the allowed jitter
+	DEADLINE_JITTER = "jitter"
+)
+
+// PeriodicFunction describes a root function that should start every Period and finish within Deadline,
+// such as loop() running at 100Hz
+type PeriodicFunction struct {
+	FuncName	string
+	Period		time.Duration
+	// defaults to Period
+	Deadline	time.Duration
+	// 0 turns off the start-to-start check
+	MaxJitter	time.Duration
+}
+
+// DeadlineMissEntry flags one call of a periodic function, FuncNumId is the root of the offending call tree.
+// durations are in microseconds
+type DeadlineMissEntry struct {
+	TraceType			uint32		`json:"traceType"`
+	DeviceId			string		`json:"deviceId"`
+	CoreId				uint32		`json:"coreId"`
+	TaskId				uint32		`json:"taskId"`
+	FuncName			string		`json:"funcName"`
+	FuncNumId			uint32		`json:"funcCallId"`
+	Kind				string		`json:"kind"`
+	StartTime			string		`json:"startTime"`
+	Duration			int64		`json:"duration"`
+	Deadline			int64		`json:"deadline"`
+	// start-to-start time since the previous call, 0 for the first call seen
+	Period				int64		`json:"period"`
+	ExpectedPeriod		int64		`json:"expectedPeriod"`
+	Jitter				int64		`json:"jitter"`
+	// whole periods that went by without the function starting at all
+	MissedCycles		int64		`json:"missedCycles"`
+	PacketId			string		`json:"packetId"`
+}
+
+func (p *Processor) isPeriodic(funcName string) bool {
+	for _, periodic := range p.PeriodicFunctions {
+		if periodic.FuncName == funcName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// periodic functions are tracked separately on every core and task they run on
+type periodicKey struct {
+	funcName	string
+	coreId		uint32
+	taskId		uint32
+}
+
+// checkPeriodic runs on every completed call and reports the ones that break their periodic function's timing
+func (p *Processor) checkPeriodic(d *deviceState, record *FormattedCompletedFunctionCall) {
+	// only roots are checked, so recursive or nested calls with the same name are left alone
+	if record.Depth != 1 || len(p.PeriodicFunctions) == 0 {
+		return
+	}
+
+	funcName := TrimCString(record.FuncName)
+	for _, periodic := range p.PeriodicFunctions {
+		if periodic.FuncName != funcName {
+			continue
+		}
+
+		startTime, _ := strconv.ParseInt(record.StartTime, 10, 64)
+		key := periodicKey{funcName: funcName, coreId: record.CoreId, taskId: record.TaskId}
+		lastStart, seenBefore := d.periodicStarts[key]
+		d.periodicStarts[key] = startTime
+
+		deadline := periodic.Deadline
+		if deadline == 0 {
+			deadline = periodic.Period
+		}
+
+		miss := DeadlineMissEntry{
+			TraceType: DEADLINE_MISS,
+			DeviceId: d.deviceId,
+			CoreId: record.CoreId,
+			TaskId: record.TaskId,
+			FuncName: funcName,
+			FuncNumId: record.FuncNumId,
+			StartTime: record.StartTime,
+			Duration: record.RunTime(),
+			Deadline: deadline.Microseconds(),
+			ExpectedPeriod: periodic.Period.Microseconds(),
+		}
+
+		if seenBefore {
+			miss.Period = startTime - lastStart
+			miss.Jitter = miss.Period - miss.ExpectedPeriod
+			if miss.ExpectedPeriod > 0 {
+				miss.MissedCycles = max(miss.Period / miss.ExpectedPeriod - 1, 0)
+			}
+		}
+
+		// a truncated call has no real end time to judge
+		if !record.Truncated && miss.Duration > miss.Deadline {
+			p.broadcastDeadlineMiss(miss, DEADLINE_OVERRUN)
+		}
+
+		if seenBefore && periodic.MaxJitter > 0 && max(miss.Jitter, -miss.Jitter) > periodic.MaxJitter.Microseconds() {
+			p.broadcastDeadlineMiss(miss, DEADLINE_JITTER)
+		}
+	}
+}
+
+func (p *Processor) broadcastDeadlineMiss(miss DeadlineMissEntry, kind string) {
+	miss.Kind = kind
+	miss.PacketId = xid.New().String()
+
+	p.Broadcaster.Broadcast(miss)
+}
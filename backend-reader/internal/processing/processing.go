@@ -1,12 +1,16 @@
 package processing
 
 import (
+	"RP-UCLA/backend-reader/internal/symbolizer"
+	tracereader "RP-UCLA/backend-reader/internal/traceReader"
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
-	"os"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 	"unsafe"
 
@@ -20,6 +24,9 @@ const (
 	TIME_BETWEEN_STATS_PACKETS = 5
 	CORE_0 = 0
 	CORE_1 = 1
+	DEFAULT_CORE_COUNT = 2
+	// used for call stacks when the firmware does not report which task is running
+	NO_TASK = 0
 )
 
 // entry type enum
@@ -30,6 +37,14 @@ const (
 	RESTART
 	FLAME_GRAPH_ENTRY // only send completed entries to the frontend
 	STAT_UPDATES
+	PACKET_LOSS
+	DIAGNOSTIC
+	TASK_SWITCH_IN
+	TASK_SWITCH_OUT
+	TASK_TIMELINE // one finished slice of a task running on a core
+	DEADLINE_MISS
+	ALERT // sent by the alerting package when a rule fires
+	SNAPSHOT // sent once to every client as it connects, see SnapshotEntry
 )
 
 // esp32 restart reasons
@@ -68,6 +83,7 @@ type FormattedTraceFunctionGeneralEntry struct {
     Timestamp   string	`json:"timestamp"`
     TraceId    	uint32	`json:"traceId"`
 	FuncNumId	uint32	`json:"funcCallId"`
+	DeviceId	string	`json:"deviceId"`
 }
 
 type TraceFunctionEnterEntry struct {
@@ -120,9 +136,15 @@ type FormattedTraceFunctionPanicEntry struct {
 	FaultingPC 			uint32 		`json:"faultingPC"`
 	ExceptionReason 	string		`json:"exceptionReason"`
 	PacketId	string			`json:"packetId"`
+
+	// only filled in when a firmware ELF has been loaded
+	FaultFunction		string		`json:"faultFunction,omitempty"`
+	FaultFile			string		`json:"faultFile,omitempty"`
+	FaultLine			int			`json:"faultLine,omitempty"`
 }
 
 type FormattedTraceFunctionRestartEntry struct {
+	DeviceId		string			`json:"deviceId"`
 	CoreId			uint32			`json:"coreId"`
 	TraceType		uint32			`json:"traceType"`
 	RestartReason	string			`json:"restartReason"`
@@ -140,79 +162,245 @@ type FormattedCompletedFunctionCall struct {
 	StartTime	string				`json:"startTime"`
 	EndTime		string				`json:"endTime"`
 	Depth		uint32				`json:"depth"`
+	TaskId		uint32				`json:"taskId"`
+	TaskName	string				`json:"taskName,omitempty"`
 
 	// track nested function calls
 	ParentFunctionId	uint32		`json:"parentFunctionId"` // NOTE: 0 can never be the parent function ID, since 0 itself is always the first function call (assuming function calls dont wrap around)
 	ChildFunctionIds	[]uint32	`json:"childFunctionIds"`
+
+	// set when packets went missing while this call was open
+	Incomplete			bool		`json:"incomplete"`
+	// set when the call never got its own EXIT and was closed while recovering the call stack
+	Truncated			bool		`json:"truncated"`
+
+	// modified z-score of the run time against the function's baseline, 0 until there is a baseline
+	AnomalyScore		float64		`json:"anomalyScore"`
+	Anomalous			bool		`json:"anomalous"`
+
+	// time spent in completed children, in microseconds
+	childRunTime		int64
+}
+
+// RunTime is the inclusive run time of a completed call in microseconds
+func (c *FormattedCompletedFunctionCall) RunTime() int64 {
+	endTime, _ := strconv.ParseInt(c.EndTime, 10, 64)
+	startTime, _ := strconv.ParseInt(c.StartTime, 10, 64)
+
+	return endTime - startTime
+}
+
+// SelfTime is the run time of a completed call minus the time spent in its children
+func (c *FormattedCompletedFunctionCall) SelfTime() int64 {
+	return max(c.RunTime() - c.childRunTime, 0)
 }
 
 type StatPacket struct {
 	TraceType   uint32 							`json:"traceType"`
+	DeviceId	string							`json:"deviceId"`
+	// goes up by one every time the board restarts, stats only ever cover the current boot
+	Boot		uint32							`json:"boot"`
 	StatMap		[]FormattedFunctionStats		`json:"statMap"`
 }
 
 type Processor struct {
-	MessageQueue 			<-chan [RAW_PACKET_SIZE]byte
-	PortName 				string
-	SocketManager 			*SocketManager
-	timeKeeper				*TimeKeeper
-	activeFuncionCalls		map[uint32]*FormattedCompletedFunctionCall
-	statTracker 			*StatTracker
-
-	core0FuncCallStack		[]uint32
-	core1FuncCallStack 		[]uint32
+	MessageQueue 			<-chan tracereader.RawPacket
+	Broadcaster 			Broadcaster
+	// anchor timestamps on when packets were recorded rather than when they are processed, for sessions read from disk
+	UseRecordedTime			bool
+	// ReceivedAt of the packet being processed, only kept up to date with UseRecordedTime
+	receivedAt				int64
+	// report TraceId gaps as PACKET_LOSS messages
+	DetectPacketLoss		bool
+	// packets with a CoreId at or above this are reported and dropped
+	CoreCount				uint32
+	// root functions whose period and run time are checked, see checkPeriodic
+	PeriodicFunctions		[]PeriodicFunction
+	// calls scoring above this against their baseline are flagged as anomalous
+	AnomalyThreshold		float64
+	// how long every device's calls are learnt from when there is no reference baseline, 0 to not learn at all
+	AnomalyLearnFor			time.Duration
+	// how far back the completed calls sent to newly connected clients go, 0 to send none
+	SnapshotWindow			time.Duration
+	// completed calls kept per device for /calls, oldest are dropped past either limit. changes apply to new devices
+	CallHistorySize			int
+	CallHistoryBytes		int64
+	foldedStacks			*FoldedStackTracker
+
+	// firmware symbols used to resolve panic addresses, keyed by device ID with "" as the fallback for every device
+	symbolizersMu			sync.RWMutex
+	symbolizers				map[string]*symbolizer.Symbolizer
+
+	// reference run times shared by every device, see SetBaseline
+	baselineMu				sync.RWMutex
+	baseline				*Baseline
+
+	// held while a packet is processed, so a snapshot never sees a call tree half way through an update
+	processingMu			sync.Mutex
+
+	// per device state, keyed by the source the packets came from
+	devicesMu				sync.Mutex
+	devices					map[string]*deviceState
 }
 
-func NewProcessor(portname string, messageQueue <-chan [RAW_PACKET_SIZE]byte, sm *SocketManager) *Processor {
+func NewProcessor(messageQueue <-chan tracereader.RawPacket, broadcaster Broadcaster) *Processor {
 	return &Processor{
 		MessageQueue: messageQueue,
-		PortName: portname,
-		SocketManager: sm,
-		timeKeeper: NewTimeKeeper(),
-		activeFuncionCalls: make(map[uint32]*FormattedCompletedFunctionCall),
-		statTracker: NewStatTracker(),
-		core0FuncCallStack: make([]uint32, 0),
-		core1FuncCallStack: make([]uint32, 0),
+		Broadcaster: broadcaster,
+		CoreCount: DEFAULT_CORE_COUNT,
+		AnomalyThreshold: DEFAULT_ANOMALY_THRESHOLD,
+		SnapshotWindow: DEFAULT_SNAPSHOT_WINDOW,
+		CallHistorySize: DEFAULT_CALL_HISTORY_SIZE,
+		CallHistoryBytes: DEFAULT_CALL_HISTORY_BYTES,
+		foldedStacks: NewFoldedStackTracker(),
+		symbolizers: make(map[string]*symbolizer.Symbolizer),
+		devices: make(map[string]*deviceState),
+	}
+}
+
+// device returns the state for a device, creating it the first time the device is seen
+func (p *Processor) device(deviceId string) *deviceState {
+	p.devicesMu.Lock()
+	defer p.devicesMu.Unlock()
+
+	if d, ok := p.devices[deviceId]; ok {
+		return d
+	}
+
+	d := newDeviceState(deviceId, newCallHistory(p.CallHistorySize, p.CallHistoryBytes), p.now)
+	p.devices[deviceId] = d
+
+	return d
+}
+
+// now is the time board timestamps are anchored on when a device is first seen or restarts
+func (p *Processor) now() int64 {
+	if p.UseRecordedTime {
+		return p.receivedAt
+	}
+
+	return time.Now().UnixMicro()
+}
+
+// deviceSnapshot returns every known device, sorted by ID so that updates go out in a stable order
+func (p *Processor) deviceSnapshot() []*deviceState {
+	p.devicesMu.Lock()
+	defer p.devicesMu.Unlock()
+
+	devices := make([]*deviceState, 0, len(p.devices))
+	for _, d := range p.devices {
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].deviceId < devices[j].deviceId })
+
+	return devices
+}
+
+// SetSymbolizer loads the firmware symbols for a device, an empty device ID applies to every device without its own
+func (p *Processor) SetSymbolizer(deviceId string, s *symbolizer.Symbolizer) {
+	p.symbolizersMu.Lock()
+	defer p.symbolizersMu.Unlock()
+
+	p.symbolizers[deviceId] = s
+}
+
+func (p *Processor) symbolizerFor(deviceId string) *symbolizer.Symbolizer {
+	p.symbolizersMu.RLock()
+	defer p.symbolizersMu.RUnlock()
+
+	if s, ok := p.symbolizers[deviceId]; ok {
+		return s
 	}
+
+	return p.symbolizers[""]
+}
+
+// WriteFoldedStacks writes every call path seen so far in folded stack format
+func (p *Processor) WriteFoldedStacks(w io.Writer, filter FoldedStackFilter) error {
+	return p.foldedStacks.Write(w, filter)
 }
 
 func (p *Processor) Process() {
-	tempBuf := <-p.MessageQueue
-	
+	packet := <-p.MessageQueue
+
+	// not held while waiting for a packet, otherwise a client connecting to an idle board would wait for the next one
+	p.processingMu.Lock()
+	defer p.processingMu.Unlock()
+
+	p.ProcessPacket(packet)
+}
+
+func (p *Processor) ProcessPacket(packet tracereader.RawPacket) {
+	tempBuf := packet.Data
+	if p.UseRecordedTime {
+		p.receivedAt = packet.ReceivedAt.UnixMicro()
+	}
+	// every source is treated as its own board
+	d := p.device(packet.Source)
+
+	if packet.Reset {
+		p.resetDevice(d)
+		return
+	}
+
 	// try to access the first byte of the message
 	// which would give you information on what type of entry it is
 	typePointer := unsafe.Pointer(&tempBuf[0])
+	traceType := *(*uint32)(typePointer)
+
+	header := TraceFunctionGeneralEntry{}
+	if err := binary.Read(bytes.NewReader(tempBuf[:]), binary.LittleEndian, &header); err != nil {
+		fmt.Printf("Error reading entry header: %v\n", err)
+		return
+	}
+
+	if header.CoreId >= p.CoreCount {
+		p.reportUnknownCore(d, &header)
+		return
+	}
+
+	// restarts reset the TraceId counter, so they are never a gap
+	if p.DetectPacketLoss && traceType != RESTART {
+		p.checkForLoss(d, &header)
+	}
 
 	streamReader := bytes.NewReader(tempBuf[:])
-	switch *(*uint32)(typePointer) {
+	switch traceType {
 	case ENTER:
 		entry := TraceFunctionEnterEntry{}
 		if err := binary.Read(streamReader, binary.LittleEndian, &entry); err != nil {
 			fmt.Printf("Error reading ENTER entry: %v\n", err)
 			return
 		}
-		p.processEntry(&entry)
+		p.processEntry(d, &entry)
 	case EXIT:
 		entry := TraceFunctionExitEntry{}
 		if err := binary.Read(streamReader, binary.LittleEndian, &entry); err != nil {
 			fmt.Printf("Error reading EXIT entry: %v\n", err)
 			return
 		}
-		p.processExit(&entry)
+		p.processExit(d, &entry)
 	case PANIC:
 		entry := TraceFunctionPanicEntry{}
 		if err := binary.Read(streamReader, binary.LittleEndian, &entry); err != nil {
 			fmt.Printf("Error reading PANIC entry: %v\n", err)
 			return
 		}
-		p.processPanic(&entry)
+		p.processPanic(d, &entry)
 	case RESTART:
 		entry := TraceFunctionRestartEntry{}
 		if err := binary.Read(streamReader, binary.LittleEndian, &entry); err != nil {
 			fmt.Printf("Error reading restart entry: %v\n", err)
 			return
 		}
-		p.processRestart(&entry)
+		p.processRestart(d, &entry)
+	case TASK_SWITCH_IN, TASK_SWITCH_OUT:
+		entry := TraceTaskSwitchEntry{}
+		if err := binary.Read(streamReader, binary.LittleEndian, &entry); err != nil {
+			fmt.Printf("Error reading task switch entry: %v\n", err)
+			return
+		}
+		p.processTaskSwitch(d, &entry)
 	default:
 		fmt.Println("Unsure")
 	}
@@ -222,14 +410,18 @@ func (p *Processor) BroadcastStats() {
 	ticker := time.NewTicker(TIME_BETWEEN_STATS_PACKETS * time.Second)
 	
 	for range ticker.C {
-		statArr := p.statTracker.GetStats()
-
-		p.SocketManager.Broadcast(
-			StatPacket{
-				TraceType: STAT_UPDATES,
-				StatMap: *statArr,
-			},
-		)
+		for _, d := range p.deviceSnapshot() {
+			statArr := d.statTracker.GetStats(d.timeKeeper.Now())
+
+			p.Broadcaster.Broadcast(
+				StatPacket{
+					TraceType: STAT_UPDATES,
+					DeviceId: d.deviceId,
+					Boot: d.statTracker.Boot(),
+					StatMap: *statArr,
+				},
+			)
+		}
 	}
 }
 
@@ -242,11 +434,11 @@ func (p *Processor) Run() {
 	}
 }
 
-func (p *Processor) processEntry(entry *TraceFunctionEnterEntry) {
+func (p *Processor) processEntry(d *deviceState, entry *TraceFunctionEnterEntry) {
 	buffer := [4]interface{}{}	
 	formatFuncArgsFromBuffer(&buffer, entry.FuncArgs, entry.ValueTypes)
 
-	funcStartTime := p.timeKeeper.GetTimestampToSend(entry.Timestamp)
+	funcStartTime := d.timeKeeper.GetTimestampToSend(entry.Timestamp)
 
 	dataToSend := FormattedTraceFunctionEnterEntry{
 		FormattedTraceFunctionGeneralEntry: FormattedTraceFunctionGeneralEntry{
@@ -255,6 +447,7 @@ func (p *Processor) processEntry(entry *TraceFunctionEnterEntry) {
 			Timestamp: strconv.FormatInt(funcStartTime, 10),
 			TraceId: entry.TraceId,
 			FuncNumId: entry.FuncNumId,
+			DeviceId: d.deviceId,
 		},
 		ArgCount: entry.ArgCount,
 		FuncArgs: buffer,
@@ -262,7 +455,7 @@ func (p *Processor) processEntry(entry *TraceFunctionEnterEntry) {
 		PacketId: xid.New().String(),
 	}
 
-	p.SocketManager.Broadcast(dataToSend)
+	p.Broadcaster.Broadcast(dataToSend)
 
 	/*
 	Each layer of nested function calls should be represented as an array
@@ -274,14 +467,10 @@ func (p *Processor) processEntry(entry *TraceFunctionEnterEntry) {
 	1. Functions that nest multiple calls (call multiple sub functions within the same function)
 	2. Functions that are recursively deep (probably some fibonacci function)
 	*/
-	var callStackToUse *[]uint32
-
-	if entry.CoreId == CORE_0 {
-		callStackToUse = &p.core0FuncCallStack
-	} else {
-		callStackToUse = &p.core1FuncCallStack
-	}
-
+	taskId := d.currentTask(entry.CoreId)
+	stackKey := callStackKey{coreId: entry.CoreId, taskId: taskId}
+	callStackToUse := d.callStack(entry.CoreId, taskId)
+	p.recoverStack(d, stackKey, callStackToUse, entry, funcStartTime)
 
 	formattedFuncEntry := FormattedCompletedFunctionCall{
 		FormattedTraceFunctionGeneralEntry: FormattedTraceFunctionGeneralEntry{
@@ -290,6 +479,7 @@ func (p *Processor) processEntry(entry *TraceFunctionEnterEntry) {
 			Timestamp: strconv.FormatInt(time.Now().UnixMicro(), 10), // NOTE: not sure if this is the best idea for now
 			TraceId: entry.TraceId,
 			FuncNumId: entry.FuncNumId,
+			DeviceId: d.deviceId,
 		},
 		ArgCount: entry.ArgCount,
 		FuncArgs: buffer,
@@ -298,24 +488,82 @@ func (p *Processor) processEntry(entry *TraceFunctionEnterEntry) {
 		StartTime: strconv.FormatInt(funcStartTime, 10),
 		ChildFunctionIds: nil,
 		Depth: uint32(len(*callStackToUse)) + 1,
+		TaskId: taskId,
+		TaskName: d.taskNames[taskId],
 	}
 
 	if len(*callStackToUse) != 0 {
-		// if there are currently entries on the function call stack, populate the child fields with that information
+		// if there are currently entries on the function call stack, populate the child fields with that information.
+		// recoverStack has made sure the top frame still has an open call
 		formattedFuncEntry.ParentFunctionId = (*callStackToUse)[len(*callStackToUse) - 1]
-		p.activeFuncionCalls[formattedFuncEntry.ParentFunctionId].ChildFunctionIds = append(p.activeFuncionCalls[formattedFuncEntry.ParentFunctionId].ChildFunctionIds, entry.FuncNumId)
+		parent, _ := d.activeCall(stackKey, formattedFuncEntry.ParentFunctionId)
+		parent.ChildFunctionIds = append(parent.ChildFunctionIds, entry.FuncNumId)
 	} else {
 		formattedFuncEntry.ParentFunctionId = 0
 	}
 
 	*callStackToUse = append(*callStackToUse, entry.FuncNumId)
 
-	p.activeFuncionCalls[entry.FuncNumId] = &formattedFuncEntry
+	d.activeFuncionCalls[activeCallKey{stackKey, entry.FuncNumId}] = &formattedFuncEntry
 }
 
-func (p *Processor) processExit(entry *TraceFunctionExitEntry) {
+// recoverStack closes frames whose EXIT was lost before a new call is pushed on top of them
+func (p *Processor) recoverStack(d *deviceState, stackKey callStackKey, callStack *[]uint32, entry *TraceFunctionEnterEntry, startTime int64) {
+	funcName := TrimCString(string(entry.FuncName[:]))
+
+	// FuncNumIds only repeat once the counter wraps, so an ID that is still open never got its EXIT
+	if frameIdx := innermostFrame(*callStack, entry.FuncNumId); frameIdx >= 0 {
+		abandoned := p.unwindAbove(d, stackKey, callStack, frameIdx - 1, startTime)
+
+		p.broadcastDiagnostic(d, DiagnosticEntry{
+			CoreId: entry.CoreId,
+			Timestamp: strconv.FormatInt(startTime, 10),
+			Kind: DIAGNOSTIC_STALE_FRAME,
+			Message: fmt.Sprintf("ENTER for %s (%d) reused the ID of a call that is still open, closed %d call(s) as truncated", funcName, entry.FuncNumId, len(abandoned)),
+			FuncNumId: entry.FuncNumId,
+			AffectedFunctionIds: abandoned,
+		})
+	}
+
+	// the root starting again means its previous call is over, however many frames are still open. a root calling itself
+	// looks the same though, so this is only trusted for periodic functions, which never recurse, or when packets went
+	// missing on this stack while the root was open, since its EXIT may well have been one of them
+	if len(*callStack) > 0 {
+		if root, ok := d.activeCall(stackKey, (*callStack)[0]); ok && TrimCString(root.FuncName) == funcName && (root.Incomplete || p.isPeriodic(funcName)) {
+			abandoned := p.unwindAbove(d, stackKey, callStack, -1, startTime)
+
+			p.broadcastDiagnostic(d, DiagnosticEntry{
+				CoreId: entry.CoreId,
+				Timestamp: strconv.FormatInt(startTime, 10),
+				Kind: DIAGNOSTIC_ROOT_RESTARTED,
+				Message: fmt.Sprintf("%s started again while its previous call was still open, closed %d call(s) as truncated", funcName, len(abandoned)),
+				FuncNumId: entry.FuncNumId,
+				AffectedFunctionIds: abandoned,
+			})
+		}
+	}
+
+	// frames without an open call can't be anyone's parent
+	for len(*callStack) > 0 {
+		top := (*callStack)[len(*callStack) - 1]
+		if _, ok := d.activeCall(stackKey, top); ok {
+			break
+		}
+
+		*callStack = (*callStack)[:len(*callStack) - 1]
+		p.broadcastDiagnostic(d, DiagnosticEntry{
+			CoreId: entry.CoreId,
+			Timestamp: strconv.FormatInt(startTime, 10),
+			Kind: DIAGNOSTIC_STALE_FRAME,
+			Message: fmt.Sprintf("call %d was left on the stack after it closed, dropped it", top),
+			FuncNumId: top,
+		})
+	}
+}
+
+func (p *Processor) processExit(d *deviceState, entry *TraceFunctionExitEntry) {
 	formattedReturnVal := formatFuncArg(entry.ReturnVal, entry.ValueTypes, 0)
-	funcEndTime := p.timeKeeper.GetTimestampToSend(entry.Timestamp)
+	funcEndTime := d.timeKeeper.GetTimestampToSend(entry.Timestamp)
 	dataToSend := FormattedTraceFunctionExitEntry{
 		FormattedTraceFunctionGeneralEntry: FormattedTraceFunctionGeneralEntry{
 			TraceType: entry.TraceType,
@@ -323,78 +571,163 @@ func (p *Processor) processExit(entry *TraceFunctionExitEntry) {
 			Timestamp: strconv.FormatInt(funcEndTime, 10),
 			TraceId: entry.TraceId,
 			FuncNumId: entry.FuncNumId,
+			DeviceId: d.deviceId,
 		},
 		ReturnVal: formattedReturnVal,
 		FuncName: string(entry.FuncName[:]),
 		PacketId: xid.New().String(),
 	}
 
-	p.SocketManager.Broadcast(dataToSend)
+	p.Broadcaster.Broadcast(dataToSend)
 
-	if record, ok := p.activeFuncionCalls[entry.FuncNumId]; ok {
-		record.ReturnVal = formattedReturnVal
-		record.EndTime = strconv.FormatInt(funcEndTime, 10)
-		p.SocketManager.Broadcast(record)
+	stackKey, ok := d.findExitStack(entry.CoreId, entry.FuncNumId)
+	if !ok {
+		// most likely the matching ENTER was lost, there is no tree to attach this exit to
+		p.broadcastDiagnostic(d, DiagnosticEntry{
+			CoreId: entry.CoreId,
+			Timestamp: strconv.FormatInt(funcEndTime, 10),
+			Kind: DIAGNOSTIC_ORPHAN_EXIT,
+			Message: fmt.Sprintf("EXIT for %s (%d) has no matching ENTER, ignoring it", TrimCString(dataToSend.FuncName), entry.FuncNumId),
+			FuncNumId: entry.FuncNumId,
+		})
+		return
+	}
 
-		p.statTracker.AddStats(record)
+	record, _ := d.activeCall(stackKey, entry.FuncNumId)
+	record.ReturnVal = formattedReturnVal
+	record.EndTime = strconv.FormatInt(funcEndTime, 10)
 
-		var callStackToUse *[]uint32
+	callStackToUse := d.callStack(stackKey.coreId, stackKey.taskId)
+	frameIdx := innermostFrame(*callStackToUse, entry.FuncNumId)
 
-		if record.CoreId == 0 {
-			callStackToUse = &p.core0FuncCallStack
-		} else {
-			callStackToUse = &p.core1FuncCallStack
-		}
+	if frameIdx >= 0 && frameIdx != len(*callStackToUse) - 1 {
+		// TODO: this is likely an error with the baudrate not being able to keep up with the data rate
+		// I do think that trying to use WiFi might help
+		abandoned := p.unwindAbove(d, stackKey, callStackToUse, frameIdx, funcEndTime)
 
-		lastIdx := len(*callStackToUse) - 1
+		p.broadcastDiagnostic(d, DiagnosticEntry{
+			CoreId: record.CoreId,
+			Timestamp: strconv.FormatInt(funcEndTime, 10),
+			Kind: DIAGNOSTIC_STACK_UNWOUND,
+			Message: fmt.Sprintf("EXIT for %s (%d) arrived with %d call(s) still open above it, closed them as truncated", TrimCString(record.FuncName), entry.FuncNumId, len(abandoned)),
+			FuncNumId: entry.FuncNumId,
+			AffectedFunctionIds: abandoned,
+		})
+	}
+
+	// unwinding only ever closes frames above this one, but a record must never be completed twice
+	if current, ok := d.activeCall(stackKey, entry.FuncNumId); ok && current == record {
+		p.completeCall(d, record)
+	}
+
+	if frameIdx >= 0 {
+		*callStackToUse = (*callStackToUse)[:frameIdx]
+	}
+}
+
+// unwindAbove closes every frame sitting above frameIdx with a synthesised, truncated exit,
+// innermost first so that parents are still open while their children complete. -1 closes the whole stack
+func (p *Processor) unwindAbove(d *deviceState, stackKey callStackKey, callStack *[]uint32, frameIdx int, endTime int64) []uint32 {
+	abandoned := make([]uint32, 0, len(*callStack) - frameIdx - 1)
 
-		if (*callStackToUse)[lastIdx] != entry.FuncNumId {
-			// TODO: this is likely an error with the baudrate not being able to keep up with the data rate
-			// I do think that trying to use WiFi might help
-			fmt.Fprintf(os.Stderr, "Last active function call is not the same as current active function call")
+	for idx := len(*callStack) - 1; idx > frameIdx; idx-- {
+		funcNumId := (*callStack)[idx]
+		abandoned = append(abandoned, funcNumId)
+
+		record, ok := d.activeCall(stackKey, funcNumId)
+		if !ok {
+			continue
 		}
-		// pop the last entry
-		*callStackToUse = (*callStackToUse)[:len(*callStackToUse) - 1]
 
-		delete(p.activeFuncionCalls, entry.FuncNumId)
+		record.EndTime = strconv.FormatInt(endTime, 10)
+		record.Truncated = true
+		p.completeCall(d, record)
+	}
+
+	*callStack = (*callStack)[:frameIdx + 1]
+
+	return abandoned
+}
+
+// completeCall sends out a finished call and folds it into the aggregates, the call stack is left to the caller
+func (p *Processor) completeCall(d *deviceState, record *FormattedCompletedFunctionCall) {
+	p.scoreAnomaly(d, record)
+	p.Broadcaster.Broadcast(record)
+
+	callPath := d.callPath(record)
+
+	// the end time of a truncated call is only an upper bound, so keep it out of the timing stats
+	if !record.Truncated {
+		d.statTracker.AddStats(record, callPath)
+	}
+
+	runTime := record.RunTime()
+	p.foldedStacks.AddCall(d.deviceId, record.CoreId, callPath, record.SelfTime(), runTime)
+	if parent, ok := d.activeCall(stackKeyOf(record), record.ParentFunctionId); ok && record.ParentFunctionId != 0 {
+		parent.childRunTime += runTime
+	}
+	p.checkPeriodic(d, record)
+	d.history.add(record, d.statTracker.Boot())
+
+	key := activeCallKey{stackKeyOf(record), record.FuncNumId}
+	if d.activeFuncionCalls[key] == record {
+		delete(d.activeFuncionCalls, key)
 	}
 }
 
-func (p *Processor) processPanic(entry *TraceFunctionPanicEntry) {
+func (p *Processor) processPanic(d *deviceState, entry *TraceFunctionPanicEntry) {
 	dataToSend := FormattedTraceFunctionPanicEntry{
 		FormattedTraceFunctionGeneralEntry: FormattedTraceFunctionGeneralEntry{
 			TraceType: entry.TraceType,
 			CoreId: entry.CoreId,
-			Timestamp: strconv.FormatInt(p.timeKeeper.GetTimestampToSend(entry.Timestamp), 10),
+			Timestamp: strconv.FormatInt(d.timeKeeper.GetTimestampToSend(entry.Timestamp), 10),
 			TraceId: entry.TraceId,
 			FuncNumId: entry.FuncNumId,
+			DeviceId: d.deviceId,
 		},
 		FaultingPC: entry.FaultingPC,
 		ExceptionReason: string(entry.ExceptionReason[:]),
 		PacketId: xid.New().String(),
 	}
-	p.SocketManager.Broadcast(dataToSend)
+
+	if s := p.symbolizerFor(d.deviceId); s != nil {
+		if symbol, ok := s.Lookup(entry.FaultingPC); ok {
+			dataToSend.FaultFunction = symbol.Function
+			dataToSend.FaultFile = symbol.File
+			dataToSend.FaultLine = symbol.Line
+		}
+	}
+
+	d.lastPanic = &dataToSend
+	p.Broadcaster.Broadcast(dataToSend)
 }
 
-func (p *Processor) processRestart(entry *TraceFunctionRestartEntry) {
-	p.timeKeeper.HandleBoardReset()
+func (p *Processor) processRestart(d *deviceState, entry *TraceFunctionRestartEntry) {
+	d.timeKeeper.HandleBoardReset()
 	dataToSend := FormattedTraceFunctionRestartEntry{
+		DeviceId: d.deviceId,
 		CoreId: entry.CoreId,
 		TraceType: RESTART,
 		RestartReason: getResetReason(entry.RestartReason),
 		PacketId: xid.New().String(),
-		Timestamp: strconv.FormatInt(p.timeKeeper.GetTimestampToSend(entry.Timestamp), 10),
-	}
-	p.SocketManager.Broadcast(dataToSend)
-
-	// drain the queue
-	for len(p.MessageQueue) > 0 {
-		<-p.MessageQueue
+		Timestamp: strconv.FormatInt(d.timeKeeper.GetTimestampToSend(entry.Timestamp), 10),
 	}
+	d.lastRestart = &dataToSend
+	p.Broadcaster.Broadcast(dataToSend)
+
+	// packets are queued in the order they arrived, so everything still queued for this device is from the new boot.
+	// only this device's state is reset, other devices are unaffected by the restart
+	d.resetCallTrees()
+	d.lossDetector.reset()
+	d.statTracker.StartSegment(dataToSend.RestartReason)
+}
 
-	p.activeFuncionCalls = make(map[uint32]*FormattedCompletedFunctionCall)
-	p.core0FuncCallStack = make([]uint32, 0)
-	p.core1FuncCallStack = make([]uint32, 0)
+// resetDevice forgets everything that ties the next packet of a device to the previous one, the way a RESTART does,
+// but leaves the stats alone. used when a replay seeks
+func (p *Processor) resetDevice(d *deviceState) {
+	d.resetCallTrees()
+	d.timeKeeper.HandleBoardReset()
+	d.lossDetector.reset()
 }
 
 func formatFuncArgsFromBuffer(buffer *[4]interface{}, funcArgs [4]uint32, valueTypes uint8) {
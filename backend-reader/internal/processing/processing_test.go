@@ -0,0 +1,343 @@
+package processing
+
+import (
+	tracereader "RP-UCLA/backend-reader/internal/traceReader"
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+	"time"
+)
+
+// recordingBroadcaster keeps everything the processor sends out
+type recordingBroadcaster struct {
+	messages	[]interface{}
+}
+
+func (r *recordingBroadcaster) Broadcast(data interface{}) {
+	r.messages = append(r.messages, data)
+}
+
+// completed returns the completed calls sent so far, keyed by funcCallId
+func (r *recordingBroadcaster) completed() map[uint32]*FormattedCompletedFunctionCall {
+	calls := make(map[uint32]*FormattedCompletedFunctionCall)
+	for _, message := range r.messages {
+		if call, ok := message.(*FormattedCompletedFunctionCall); ok {
+			calls[call.FuncNumId] = call
+		}
+	}
+
+	return calls
+}
+
+// completions returns every completed call sent for funcCallId, in the order they were sent
+func (r *recordingBroadcaster) completions(funcNumId uint32) []*FormattedCompletedFunctionCall {
+	calls := make([]*FormattedCompletedFunctionCall, 0)
+	for _, message := range r.messages {
+		if call, ok := message.(*FormattedCompletedFunctionCall); ok && call.FuncNumId == funcNumId {
+			calls = append(calls, call)
+		}
+	}
+
+	return calls
+}
+
+func (r *recordingBroadcaster) diagnostics() []DiagnosticEntry {
+	diagnostics := make([]DiagnosticEntry, 0)
+	for _, message := range r.messages {
+		if diagnostic, ok := message.(DiagnosticEntry); ok {
+			diagnostics = append(diagnostics, diagnostic)
+		}
+	}
+
+	return diagnostics
+}
+
+func (r *recordingBroadcaster) packetLosses() []PacketLossEntry {
+	losses := make([]PacketLossEntry, 0)
+	for _, message := range r.messages {
+		if loss, ok := message.(PacketLossEntry); ok {
+			losses = append(losses, loss)
+		}
+	}
+
+	return losses
+}
+
+// testBoard feeds raw packets for a single board through ProcessPacket, numbering them the way the firmware does
+type testBoard struct {
+	t				*testing.T
+	processor		*Processor
+	broadcaster		*recordingBroadcaster
+	traceId			uint32
+}
+
+func newTestBoard(t *testing.T) *testBoard {
+	broadcaster := &recordingBroadcaster{}
+
+	return &testBoard{
+		t: t,
+		processor: NewProcessor(nil, broadcaster),
+		broadcaster: broadcaster,
+	}
+}
+
+func (b *testBoard) header(traceType uint32, coreId uint32, funcNumId uint32) TraceFunctionGeneralEntry {
+	b.traceId++
+
+	return TraceFunctionGeneralEntry{
+		TraceType: traceType,
+		CoreId: coreId,
+		Timestamp: b.traceId * 100,
+		TraceId: b.traceId,
+		FuncNumId: funcNumId,
+	}
+}
+
+func (b *testBoard) send(entry interface{}) {
+	buf := bytes.Buffer{}
+	if err := binary.Write(&buf, binary.LittleEndian, entry); err != nil {
+		b.t.Fatalf("encoding %T: %v", entry, err)
+	}
+
+	packet := tracereader.RawPacket{Source: "board"}
+	if buf.Len() > len(packet.Data) {
+		b.t.Fatalf("%T is %d bytes, packets only hold %d", entry, buf.Len(), len(packet.Data))
+	}
+	copy(packet.Data[:], buf.Bytes())
+
+	b.processor.ProcessPacket(packet)
+}
+
+func (b *testBoard) enter(coreId uint32, funcNumId uint32, funcName string) {
+	entry := TraceFunctionEnterEntry{TraceFunctionGeneralEntry: b.header(ENTER, coreId, funcNumId)}
+	copy(entry.FuncName[:], funcName)
+	b.send(entry)
+}
+
+func (b *testBoard) exit(coreId uint32, funcNumId uint32, funcName string) {
+	entry := TraceFunctionExitEntry{TraceFunctionGeneralEntry: b.header(EXIT, coreId, funcNumId)}
+	copy(entry.FuncName[:], funcName)
+	b.send(entry)
+}
+
+// lose skips TraceIds as if that many packets never arrived
+func (b *testBoard) lose(count uint32) {
+	b.traceId += count
+}
+
+func TestPacketLossOnlyMarksTheReceivingStack(t *testing.T) {
+	board := newTestBoard(t)
+	board.processor.DetectPacketLoss = true
+
+	board.enter(0, 1, "loop")
+	board.enter(1, 2, "radioTx")
+	board.lose(3)
+	// the gap lands on core 0, core 1 carried on without it as far as anyone can tell
+	board.enter(0, 3, "readSensors")
+	board.exit(0, 3, "readSensors")
+	board.exit(0, 1, "loop")
+	board.exit(1, 2, "radioTx")
+
+	losses := board.broadcaster.packetLosses()
+	if len(losses) != 1 {
+		t.Fatalf("got %d PACKET_LOSS messages, want 1", len(losses))
+	}
+	if losses[0].PacketsLost != 3 || !slices.Equal(losses[0].AffectedFunctionIds, []uint32{1}) {
+		t.Errorf("PACKET_LOSS lost %d affecting %v, want 3 affecting [1]", losses[0].PacketsLost, losses[0].AffectedFunctionIds)
+	}
+
+	calls := board.broadcaster.completed()
+	for funcNumId, wantIncomplete := range map[uint32]bool{1: true, 2: false, 3: false} {
+		call, ok := calls[funcNumId]
+		if !ok {
+			t.Fatalf("call %d never completed", funcNumId)
+		}
+		if call.Incomplete != wantIncomplete {
+			t.Errorf("call %d Incomplete = %v, want %v", funcNumId, call.Incomplete, wantIncomplete)
+		}
+		if call.Truncated {
+			t.Errorf("call %d was truncated, a gap alone should not close anything", funcNumId)
+		}
+	}
+
+	// the ENTER after the gap still nests under the call that was open before it
+	if parent := calls[3].ParentFunctionId; parent != 1 {
+		t.Errorf("call 3 has parent %d, want 1", parent)
+	}
+}
+
+func TestPacketLossTraceIdReset(t *testing.T) {
+	board := newTestBoard(t)
+	board.processor.DetectPacketLoss = true
+
+	board.traceId = 5000
+	board.enter(0, 1, "loop")
+	board.exit(0, 1, "loop")
+	// a board that restarted without its RESTART arriving counts from the start again
+	board.traceId = 0
+	board.enter(0, 2, "loop")
+	board.lose(1)
+	board.exit(0, 2, "loop")
+
+	diagnostics := board.broadcaster.diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].Kind != DIAGNOSTIC_TRACE_ID_RESET {
+		t.Fatalf("got diagnostics %+v, want a single %s", diagnostics, DIAGNOSTIC_TRACE_ID_RESET)
+	}
+
+	// counting carries on from the new TraceIds, so only the packet lost after the reset is reported
+	losses := board.broadcaster.packetLosses()
+	if len(losses) != 1 || losses[0].PacketsLost != 1 || losses[0].LastTraceId != 1 {
+		t.Errorf("got PACKET_LOSS messages %+v, want one for a single packet after TraceId 1", losses)
+	}
+}
+
+// wantDiagnostics checks the kinds and affected calls of every diagnostic sent so far
+func (b *testBoard) wantDiagnostics(want ...DiagnosticEntry) {
+	b.t.Helper()
+
+	got := b.broadcaster.diagnostics()
+	if len(got) != len(want) {
+		b.t.Fatalf("got %d diagnostics %+v, want %d", len(got), got, len(want))
+	}
+
+	for idx := range want {
+		if got[idx].Kind != want[idx].Kind || got[idx].FuncNumId != want[idx].FuncNumId || !slices.Equal(got[idx].AffectedFunctionIds, want[idx].AffectedFunctionIds) {
+			b.t.Errorf("diagnostic %d is %s for %d affecting %v, want %s for %d affecting %v", idx,
+				got[idx].Kind, got[idx].FuncNumId, got[idx].AffectedFunctionIds, want[idx].Kind, want[idx].FuncNumId, want[idx].AffectedFunctionIds)
+		}
+	}
+}
+
+// wantCall checks the latest completion of funcCallId
+func (b *testBoard) wantCall(funcNumId uint32, parent uint32, truncated bool) {
+	b.t.Helper()
+
+	calls := b.broadcaster.completions(funcNumId)
+	if len(calls) == 0 {
+		b.t.Fatalf("call %d never completed", funcNumId)
+	}
+
+	call := calls[len(calls) - 1]
+	if call.ParentFunctionId != parent || call.Truncated != truncated {
+		b.t.Errorf("call %d has parent %d and truncated %v, want parent %d and truncated %v", funcNumId, call.ParentFunctionId, call.Truncated, parent, truncated)
+	}
+}
+
+func TestRecoverReusedFrame(t *testing.T) {
+	board := newTestBoard(t)
+
+	board.enter(0, 1, "loop")
+	board.enter(0, 2, "readSensors")
+	board.enter(0, 3, "i2cRead")
+	// the EXITs of 3 and 2 never arrived, and the ID counter came back round to 2
+	board.enter(0, 2, "readSensors")
+	board.exit(0, 2, "readSensors")
+	board.exit(0, 1, "loop")
+
+	board.wantDiagnostics(DiagnosticEntry{Kind: DIAGNOSTIC_STALE_FRAME, FuncNumId: 2, AffectedFunctionIds: []uint32{3, 2}})
+
+	if calls := board.broadcaster.completions(2); len(calls) != 2 || !calls[0].Truncated || calls[1].Truncated {
+		t.Errorf("got %d completions of call 2, want the stale one truncated then the new one complete", len(calls))
+	}
+	board.wantCall(3, 2, true)
+	board.wantCall(2, 1, false)
+	board.wantCall(1, 0, false)
+}
+
+func TestRecoverUnwindOnExit(t *testing.T) {
+	board := newTestBoard(t)
+
+	board.enter(0, 1, "loop")
+	board.enter(0, 2, "readSensors")
+	board.enter(0, 3, "i2cRead")
+	// the EXITs of 3 and 2 never arrived
+	board.exit(0, 1, "loop")
+	board.enter(0, 4, "loop")
+	board.exit(0, 4, "loop")
+
+	board.wantDiagnostics(DiagnosticEntry{Kind: DIAGNOSTIC_STACK_UNWOUND, FuncNumId: 1, AffectedFunctionIds: []uint32{3, 2}})
+	board.wantCall(3, 2, true)
+	board.wantCall(2, 1, true)
+	board.wantCall(1, 0, false)
+	// the stack is empty again, so the next loop is a root
+	board.wantCall(4, 0, false)
+}
+
+func TestRecoverOrphanExit(t *testing.T) {
+	board := newTestBoard(t)
+
+	board.enter(0, 1, "loop")
+	// the ENTER of 2 never arrived
+	board.exit(0, 2, "readSensors")
+	board.exit(0, 1, "loop")
+
+	board.wantDiagnostics(DiagnosticEntry{Kind: DIAGNOSTIC_ORPHAN_EXIT, FuncNumId: 2, AffectedFunctionIds: []uint32{}})
+	if calls := board.broadcaster.completions(2); len(calls) != 0 {
+		t.Errorf("the orphan EXIT completed %d calls, want none", len(calls))
+	}
+	board.wantCall(1, 0, false)
+}
+
+func TestRecoverRootRestart(t *testing.T) {
+	t.Run("periodic function", func(t *testing.T) {
+		board := newTestBoard(t)
+		board.processor.PeriodicFunctions = []PeriodicFunction{{FuncName: "loop", Period: time.Millisecond}}
+
+		board.enter(0, 1, "loop")
+		board.enter(0, 2, "readSensors")
+		// the EXITs of 2 and 1 never arrived
+		board.enter(0, 3, "loop")
+		board.exit(0, 3, "loop")
+
+		board.wantDiagnostics(DiagnosticEntry{Kind: DIAGNOSTIC_ROOT_RESTARTED, FuncNumId: 3, AffectedFunctionIds: []uint32{2, 1}})
+		board.wantCall(2, 1, true)
+		board.wantCall(1, 0, true)
+		board.wantCall(3, 0, false)
+	})
+
+	t.Run("any function after a gap", func(t *testing.T) {
+		board := newTestBoard(t)
+		board.processor.DetectPacketLoss = true
+
+		board.enter(0, 1, "taskMain")
+		board.enter(0, 2, "readSensors")
+		board.lose(2)
+		board.enter(0, 3, "taskMain")
+		board.exit(0, 3, "taskMain")
+
+		board.wantDiagnostics(DiagnosticEntry{Kind: DIAGNOSTIC_ROOT_RESTARTED, FuncNumId: 3, AffectedFunctionIds: []uint32{2, 1}})
+		board.wantCall(1, 0, true)
+		board.wantCall(3, 0, false)
+	})
+
+	t.Run("recursion is left alone", func(t *testing.T) {
+		board := newTestBoard(t)
+		board.processor.DetectPacketLoss = true
+
+		board.enter(0, 1, "fib")
+		board.enter(0, 2, "fib")
+		board.exit(0, 2, "fib")
+		board.exit(0, 1, "fib")
+
+		board.wantDiagnostics()
+		board.wantCall(2, 1, false)
+		board.wantCall(1, 0, false)
+	})
+}
+
+func TestRecoverDropsStaleFrames(t *testing.T) {
+	board := newTestBoard(t)
+
+	board.enter(0, 1, "loop")
+	// a frame whose call has already closed, which nothing should ever be nested under
+	callStack := board.processor.device("board").callStack(0, NO_TASK)
+	*callStack = append(*callStack, 9)
+	board.enter(0, 2, "readSensors")
+	board.exit(0, 2, "readSensors")
+	board.exit(0, 1, "loop")
+
+	board.wantDiagnostics(DiagnosticEntry{Kind: DIAGNOSTIC_STALE_FRAME, FuncNumId: 9, AffectedFunctionIds: []uint32{}})
+	board.wantCall(2, 1, false)
+	board.wantCall(1, 0, false)
+}
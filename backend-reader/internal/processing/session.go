@@ -0,0 +1,38 @@
+package processing
+
+import (
+	"RP-UCLA/backend-reader/internal/recording"
+	"errors"
+	"io"
+)
+
+// ProcessSession runs a recorded session through a fresh Processor as fast as possible,
+// handing everything it produces to the broadcaster instead of the websocket clients.
+// The processor is returned so aggregated state such as folded stacks can be read back out.
+// coreCount should match the chips the session was recorded from, packets from cores past it are dropped
+func ProcessSession(path string, coreCount uint32, broadcaster Broadcaster) (*Processor, error) {
+	session, err := recording.OpenSession(path)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	processor := NewProcessor(nil, broadcaster)
+	processor.CoreCount = coreCount
+	// the wall clock would squash a long session into however long it takes to read,
+	// and put everything after a restart on top of what came before it
+	processor.UseRecordedTime = true
+
+	for {
+		packet, err := session.Next()
+		// a torn write at the end just means the recorder was killed mid packet
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return processor, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		processor.ProcessPacket(packet)
+	}
+}
@@ -0,0 +1,114 @@
+package processing
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+const (
+	// how far back the completed calls in a snapshot go
+	DEFAULT_SNAPSHOT_WINDOW = 10 * time.Second
+)
+
+// SnapshotProvider hands new websocket clients the state they missed by connecting mid session.
+// register is called with whatever should be sent first, and nothing is broadcast until it returns,
+// so the client neither misses nor repeats anything between the snapshot and the live stream
+type SnapshotProvider interface {
+	Snapshot(register func(snapshot []interface{}))
+}
+
+// OpenCallStack is the calls still running on one core and task, outermost first
+type OpenCallStack struct {
+	CoreId		uint32								`json:"coreId"`
+	TaskId		uint32								`json:"taskId"`
+	Calls		[]FormattedCompletedFunctionCall	`json:"calls"`
+}
+
+// SnapshotEntry catches a newly connected client up on one device
+type SnapshotEntry struct {
+	TraceType		uint32									`json:"traceType"`
+	DeviceId		string									`json:"deviceId"`
+	OpenCalls		[]OpenCallStack							`json:"openCalls"`
+	Stats			StatPacket								`json:"stats"`
+	// nil until the device has restarted or panicked
+	LastRestart		*FormattedTraceFunctionRestartEntry		`json:"lastRestart"`
+	LastPanic		*FormattedTraceFunctionPanicEntry		`json:"lastPanic"`
+	// calls that completed in the last SnapshotWindow, oldest first
+	RecentCalls		[]*FormattedCompletedFunctionCall		`json:"recentCalls"`
+}
+
+// Snapshot pauses processing while the snapshot of every device is taken and the client is registered
+func (p *Processor) Snapshot(register func(snapshot []interface{})) {
+	p.processingMu.Lock()
+	defer p.processingMu.Unlock()
+
+	devices := p.deviceSnapshot()
+	snapshot := make([]interface{}, 0, len(devices))
+	for _, d := range devices {
+		snapshot = append(snapshot, p.snapshotDevice(d))
+	}
+
+	register(snapshot)
+}
+
+// snapshotDevice assumes processingMu is held
+func (p *Processor) snapshotDevice(d *deviceState) SnapshotEntry {
+	snapshot := SnapshotEntry{
+		TraceType: SNAPSHOT,
+		DeviceId: d.deviceId,
+		OpenCalls: d.openCalls(),
+		Stats: StatPacket{
+			TraceType: STAT_UPDATES,
+			DeviceId: d.deviceId,
+			Boot: d.statTracker.Boot(),
+			StatMap: *d.statTracker.GetStats(d.timeKeeper.Now()),
+		},
+		LastRestart: d.lastRestart,
+		LastPanic: d.lastPanic,
+		RecentCalls: make([]*FormattedCompletedFunctionCall, 0),
+	}
+
+	if p.SnapshotWindow > 0 {
+		if latestEnd := d.history.latestEnd(); latestEnd != 0 {
+			snapshot.RecentCalls = d.history.since(latestEnd - p.SnapshotWindow.Microseconds())
+		}
+	}
+
+	return snapshot
+}
+
+// openCalls copies every call still on a stack, open calls keep changing as their children complete
+func (d *deviceState) openCalls() []OpenCallStack {
+	keys := make([]callStackKey, 0, len(d.callStacks))
+	for key, stack := range d.callStacks {
+		if len(*stack) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].coreId != keys[j].coreId {
+			return keys[i].coreId < keys[j].coreId
+		}
+		return keys[i].taskId < keys[j].taskId
+	})
+
+	openCalls := make([]OpenCallStack, 0, len(keys))
+	for _, key := range keys {
+		stack := OpenCallStack{CoreId: key.coreId, TaskId: key.taskId}
+		for _, funcNumId := range *d.callStacks[key] {
+			record, ok := d.activeCall(key, funcNumId)
+			if !ok {
+				continue
+			}
+
+			call := *record
+			call.ChildFunctionIds = slices.Clone(record.ChildFunctionIds)
+			stack.Calls = append(stack.Calls, call)
+		}
+
+		openCalls = append(openCalls, stack)
+	}
+
+	return openCalls
+}
@@ -1,19 +1,62 @@
 package processing
 
 import (
+	"sort"
 	"strconv"
 	"sync"
 )
 
+const (
+	// older boots are dropped once this many have been kept
+	MAX_STAT_SEGMENTS = 32
+)
+
+// run times include time spent in children, self times leave it out, everything is in microseconds
 type FunctionStats struct {
 	CallsMade		int64		`json:"callsMade"`
 	AverageRunTime	float64		`json:"averageRunTime"`
 	MaxRunTime		int64		`json:"maxRunTime"`
+	MinRunTime		int64		`json:"minRunTime"`
+	TotalRunTime	int64		`json:"totalRunTime"`
+	AverageSelfTime	float64		`json:"averageSelfTime"`
+	MaxSelfTime		int64		`json:"maxSelfTime"`
+	TotalSelfTime	int64		`json:"totalSelfTime"`
+
+	// run time percentiles come from the function's histogram, so they are accurate to within a bucket
+	P50				int64		`json:"p50"`
+	P90				int64		`json:"p90"`
+	P99				int64		`json:"p99"`
+	P999			int64		`json:"p999"`
+	StdDev			float64		`json:"stdDev"`
+
+	// the same numbers over the last 1s, 10s and 60s
+	Windows			map[string]WindowStats	`json:"windows"`
 }
 
+// StatSegment holds the stats of one boot of the board, so that a reboot's stats are not blended with the previous boot
+type StatSegment struct {
+	Boot			uint32						`json:"boot"`
+	// end times of the first and last calls seen during the boot
+	StartTime		string						`json:"startTime"`
+	EndTime			string						`json:"endTime"`
+	// what ended the boot, empty for the boot that is still running
+	EndReason		string						`json:"endReason"`
+	StatMap			[]FormattedFunctionStats	`json:"statMap"`
+}
+
+// StatTracker only covers the current boot, earlier boots are archived as segments
 type StatTracker struct {
 	mu			sync.Mutex
 	StatMap		map[string]*FunctionStats
+	histograms	map[string]*Histogram
+	windows		map[string]*statWindow
+	pathStats	map[string]*CallPathStats
+	edges		map[callGraphEdgeKey]*CallGraphEdge
+
+	boot		uint32
+	firstEnd	int64
+	latestEnd	int64
+	segments	[]StatSegment
 }
 
 type FormattedFunctionStats struct {
@@ -21,53 +64,210 @@ type FormattedFunctionStats struct {
 	FuncName	string `json:"funcName"`
 }
 
+// FunctionHistogram is the full run time distribution of one function, for plotting
+type FunctionHistogram struct {
+	FuncName	string				`json:"funcName"`
+	Count		uint64				`json:"count"`
+	Min			int64				`json:"min"`
+	Max			int64				`json:"max"`
+	Mean		float64				`json:"mean"`
+	StdDev		float64				`json:"stdDev"`
+	Buckets		[]HistogramBucket	`json:"buckets"`
+}
+
 func NewStatTracker() *StatTracker {
 	return &StatTracker{
 		StatMap: make(map[string]*FunctionStats),
+		histograms: make(map[string]*Histogram),
+		windows: make(map[string]*statWindow),
+		pathStats: make(map[string]*CallPathStats),
+		edges: make(map[callGraphEdgeKey]*CallGraphEdge),
+		segments: make([]StatSegment, 0),
+	}
+}
+
+// reset assumes the lock is held
+func (s *StatTracker) reset() {
+	s.StatMap = make(map[string]*FunctionStats)
+	s.histograms = make(map[string]*Histogram)
+	s.windows = make(map[string]*statWindow)
+	s.pathStats = make(map[string]*CallPathStats)
+	s.edges = make(map[callGraphEdgeKey]*CallGraphEdge)
+	s.firstEnd = 0
+	s.latestEnd = 0
+}
+
+// Reset throws away the stats of the current boot without starting a new one
+func (s *StatTracker) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reset()
+}
+
+// StartSegment archives the stats of the current boot and starts counting the next one from scratch
+func (s *StatTracker) StartSegment(endReason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the boot is over, so its windows end with its last call
+	s.segments = append(s.segments, s.currentSegment(endReason, s.latestEnd))
+	if len(s.segments) > MAX_STAT_SEGMENTS {
+		s.segments = s.segments[len(s.segments) - MAX_STAT_SEGMENTS:]
+	}
+
+	s.boot++
+	s.reset()
+}
+
+// Boot counts the restarts seen since the tracker was created
+func (s *StatTracker) Boot() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.boot
+}
+
+// GetSegments returns every archived boot followed by the current one, now is as for GetStats
+func (s *StatTracker) GetSegments(now int64) []StatSegment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	segments := make([]StatSegment, 0, len(s.segments) + 1)
+	segments = append(segments, s.segments...)
+
+	return append(segments, s.currentSegment("", now))
+}
+
+// currentSegment assumes the lock is held
+func (s *StatTracker) currentSegment(endReason string, now int64) StatSegment {
+	return StatSegment{
+		Boot: s.boot,
+		StartTime: strconv.FormatInt(s.firstEnd, 10),
+		EndTime: strconv.FormatInt(s.latestEnd, 10),
+		EndReason: endReason,
+		StatMap: s.formattedStats(now),
 	}
 }
 
-func (s *StatTracker) AddStats(entry *FormattedCompletedFunctionCall) {
+// AddStats counts a completed call both under its name and under callPath, the chain of callers that led to it
+func (s *StatTracker) AddStats(entry *FormattedCompletedFunctionCall, callPath string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	// record funcRunTime in microseconds
-	endTime, _ := strconv.ParseInt(entry.EndTime, 10, 64)
-	startTime, _ := strconv.ParseInt(entry.StartTime, 10, 64)
-	funcRunTime := (endTime - startTime)
+	funcRunTime := entry.RunTime()
+	// children have always completed by the time their parent does, so this is final
+	funcSelfTime := entry.SelfTime()
 	
 	if record, ok := s.StatMap[entry.FuncName]; ok {
-		record.AverageRunTime = record.AverageRunTime * float64(record.CallsMade) + float64(funcRunTime)
-
 		record.CallsMade++
-		record.AverageRunTime /= float64(record.CallsMade)
+		// NOTE: recursive calls count towards the total run time at every level they are nested at
+		record.TotalRunTime += funcRunTime
+		record.TotalSelfTime += funcSelfTime
+		record.AverageRunTime = float64(record.TotalRunTime) / float64(record.CallsMade)
+		record.AverageSelfTime = float64(record.TotalSelfTime) / float64(record.CallsMade)
 		record.MaxRunTime = max(funcRunTime, record.MaxRunTime)
+		record.MinRunTime = min(funcRunTime, record.MinRunTime)
+		record.MaxSelfTime = max(funcSelfTime, record.MaxSelfTime)
 	} else {
 		s.StatMap[entry.FuncName] = &FunctionStats{
 			CallsMade: 1,
 			AverageRunTime: float64(funcRunTime),
 			MaxRunTime: funcRunTime,
+			MinRunTime: funcRunTime,
+			TotalRunTime: funcRunTime,
+			AverageSelfTime: float64(funcSelfTime),
+			MaxSelfTime: funcSelfTime,
+			TotalSelfTime: funcSelfTime,
 		}
+		s.histograms[entry.FuncName] = NewHistogram()
+	}
+
+	s.histograms[entry.FuncName].Record(funcRunTime)
+
+	endTime, _ := strconv.ParseInt(entry.EndTime, 10, 64)
+	if s.firstEnd == 0 {
+		s.firstEnd = endTime
+	}
+	s.latestEnd = max(s.latestEnd, endTime)
+
+	window, ok := s.windows[entry.FuncName]
+	if !ok {
+		window = &statWindow{}
+		s.windows[entry.FuncName] = window
 	}
+	window.add(endTime, funcRunTime, funcSelfTime)
+
+	s.addCallPath(callPath, funcRunTime, funcSelfTime)
 }
 
-func (s *StatTracker) GetStats() *[]FormattedFunctionStats {
+// GetStats reports the windows as of now, the current time from the device's TimeKeeper,
+// so they empty out when the board stops sending calls
+func (s *StatTracker) GetStats(now int64) *[]FormattedFunctionStats {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	funcStatArr := s.formattedStats(now)
+	return &funcStatArr
+}
+
+// formattedStats assumes the lock is held
+func (s *StatTracker) formattedStats(now int64) []FormattedFunctionStats {
+	// a board clock running slightly fast can put the newest calls a little after now, they still count
+	latestSecond := max(now, s.latestEnd) / 1_000_000
+
 	funcStatArr := make([]FormattedFunctionStats, 0, len(s.StatMap))
 	for funcName, funcStats := range s.StatMap {
+		stats := *funcStats
+		if histogram, ok := s.histograms[funcName]; ok {
+			stats.P50 = histogram.Percentile(0.5)
+			stats.P90 = histogram.Percentile(0.9)
+			stats.P99 = histogram.Percentile(0.99)
+			stats.P999 = histogram.Percentile(0.999)
+			stats.StdDev = histogram.StdDev()
+		}
+
+		if window, ok := s.windows[funcName]; ok {
+			stats.Windows = make(map[string]WindowStats, len(STAT_WINDOWS))
+			for _, seconds := range STAT_WINDOWS {
+				stats.Windows[windowName(seconds)] = window.summarise(latestSecond, seconds)
+			}
+		}
+
 		funcStatArr = append(funcStatArr, 
 			FormattedFunctionStats{
-				FunctionStats: FunctionStats{
-					CallsMade: funcStats.CallsMade,
-					AverageRunTime: funcStats.AverageRunTime,
-					MaxRunTime: funcStats.MaxRunTime,
-				},
+				FunctionStats: stats,
 				FuncName: funcName,
 			},
 		)
 	}
 
-	return &funcStatArr
+	return funcStatArr
+}
+
+// GetHistograms copies out the run time histogram of every function, or just funcName when it is not empty
+func (s *StatTracker) GetHistograms(funcName string) []FunctionHistogram {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	histograms := make([]FunctionHistogram, 0, len(s.histograms))
+	for name, histogram := range s.histograms {
+		if funcName != "" && TrimCString(name) != funcName {
+			continue
+		}
+
+		histograms = append(histograms, FunctionHistogram{
+			FuncName: TrimCString(name),
+			Count: histogram.Count(),
+			Min: histogram.min,
+			Max: histogram.max,
+			Mean: histogram.mean,
+			StdDev: histogram.StdDev(),
+			Buckets: histogram.Buckets(),
+		})
+	}
+	sort.Slice(histograms, func(i, j int) bool { return histograms[i].FuncName < histograms[j].FuncName })
+
+	return histograms
 }
\ No newline at end of file
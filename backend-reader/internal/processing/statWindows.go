@@ -0,0 +1,91 @@
+package processing
+
+import (
+	"fmt"
+)
+
+const (
+	// one slot per second, enough to cover the longest window
+	STAT_WINDOW_SLOTS = 60
+)
+
+// windows reported with every stat update, in seconds
+var STAT_WINDOWS = []int64{1, 10, 60}
+
+// WindowStats summarises the calls that finished within the last few seconds, in microseconds
+type WindowStats struct {
+	CallsMade		int64		`json:"callsMade"`
+	AverageRunTime	float64		`json:"averageRunTime"`
+	MinRunTime		int64		`json:"minRunTime"`
+	MaxRunTime		int64		`json:"maxRunTime"`
+	AverageSelfTime	float64		`json:"averageSelfTime"`
+}
+
+type windowSlot struct {
+	second			int64
+	calls			int64
+	totalRunTime	int64
+	totalSelfTime	int64
+	minRunTime		int64
+	maxRunTime		int64
+}
+
+// statWindow buckets a function's calls by the second they finished in, going by the end times of the calls.
+// the windows themselves end at the current time, see StatTracker.GetStats
+type statWindow struct {
+	slots		[STAT_WINDOW_SLOTS]windowSlot
+}
+
+func (w *statWindow) add(endTime int64, runTime int64, selfTime int64) {
+	second := endTime / 1_000_000
+	// end times before the epoch, e.g. from a session recorded without receive times, still need a slot
+	slot := &w.slots[(second % STAT_WINDOW_SLOTS + STAT_WINDOW_SLOTS) % STAT_WINDOW_SLOTS]
+
+	if slot.calls > 0 && second < slot.second {
+		// the slot already holds a newer second, so this call finished before the longest window
+		return
+	}
+
+	// the slot still holds a second that has scrolled out of every window
+	if second > slot.second || slot.calls == 0 {
+		*slot = windowSlot{second: second, minRunTime: runTime, maxRunTime: runTime}
+	}
+
+	slot.calls++
+	slot.totalRunTime += runTime
+	slot.totalSelfTime += selfTime
+	slot.minRunTime = min(slot.minRunTime, runTime)
+	slot.maxRunTime = max(slot.maxRunTime, runTime)
+}
+
+// summarise covers the seconds in (latestSecond - seconds, latestSecond]
+func (w *statWindow) summarise(latestSecond int64, seconds int64) WindowStats {
+	stats := WindowStats{}
+	totalRunTime, totalSelfTime := int64(0), int64(0)
+
+	for _, slot := range w.slots {
+		if slot.calls == 0 || slot.second > latestSecond || slot.second <= latestSecond - seconds {
+			continue
+		}
+
+		if stats.CallsMade == 0 {
+			stats.MinRunTime = slot.minRunTime
+		}
+		stats.CallsMade += slot.calls
+		totalRunTime += slot.totalRunTime
+		totalSelfTime += slot.totalSelfTime
+		stats.MinRunTime = min(stats.MinRunTime, slot.minRunTime)
+		stats.MaxRunTime = max(stats.MaxRunTime, slot.maxRunTime)
+	}
+
+	if stats.CallsMade > 0 {
+		stats.AverageRunTime = float64(totalRunTime) / float64(stats.CallsMade)
+		stats.AverageSelfTime = float64(totalSelfTime) / float64(stats.CallsMade)
+	}
+
+	return stats
+}
+
+func windowName(seconds int64) string {
+	return fmt.Sprintf("%ds", seconds)
+}
@@ -0,0 +1,27 @@
+package processing
+
+import (
+	"testing"
+)
+
+func TestStatWindowIgnoresCallsOlderThanTheWindow(t *testing.T) {
+	window := statWindow{}
+	window.add(70_500_000, 100, 100)
+	// lands in the same slot as second 70, but is far too old to be in any window
+	window.add(10_500_000, 5000, 5000)
+	window.add(70_900_000, 300, 300)
+
+	stats := window.summarise(70, 60)
+	if stats.CallsMade != 2 || stats.MinRunTime != 100 || stats.MaxRunTime != 300 {
+		t.Errorf("got %d calls between %dus and %dus, want 2 between 100us and 300us", stats.CallsMade, stats.MinRunTime, stats.MaxRunTime)
+	}
+}
+
+func TestStatWindowNegativeEndTimes(t *testing.T) {
+	window := statWindow{}
+	window.add(-2_500_000, 100, 100)
+
+	if stats := window.summarise(0, 10); stats.CallsMade != 1 {
+		t.Errorf("got %d calls, want 1", stats.CallsMade)
+	}
+}
@@ -1,8 +1,14 @@
 package processing
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -10,6 +16,34 @@ import (
 const (
 	READ_BUFFER_SIZE = 128
 	WRITE_BUFFER_SIZE = 1024
+
+	DEFAULT_CLIENT_QUEUE_SIZE = 256
+	// a client that can't take a single message in this long is treated as gone
+	CLIENT_WRITE_TIMEOUT = 5 * time.Second
+
+	// how often batched clients get a frame
+	DEFAULT_BATCH_INTERVAL = 50 * time.Millisecond
+)
+
+// websocket subprotocols a client can ask for, clients that don't ask get one JSON text frame per message.
+// the batch variants send one frame every batch interval holding an array of every message since the last one,
+// the msgpack variants send binary frames with the same objects encoded as MessagePack
+const (
+	SUBPROTOCOL_JSON = "hermes.json"
+	SUBPROTOCOL_JSON_BATCH = "hermes.json.batch"
+	SUBPROTOCOL_MSGPACK = "hermes.msgpack"
+	SUBPROTOCOL_MSGPACK_BATCH = "hermes.msgpack.batch"
+)
+
+// what to do when a client's queue is full
+const (
+	// throw away the oldest queued message to make room
+	DROP_OLDEST = "drop-oldest"
+	// throw away high volume messages such as completed calls and stats, critical ones evict the oldest of those instead.
+	// a client whose queue is all critical messages is disconnected
+	DROP_NONCRITICAL = "drop-noncritical"
+	// give up on the client
+	DISCONNECT = "disconnect"
 )
 
 var Upgrader = websocket.Upgrader{
@@ -17,45 +51,417 @@ var Upgrader = websocket.Upgrader{
 	WriteBufferSize: WRITE_BUFFER_SIZE,
 	// allow all sends from localhost
 	CheckOrigin: func (r *http.Request) bool { return true },
+	Subprotocols: []string{SUBPROTOCOL_JSON, SUBPROTOCOL_JSON_BATCH, SUBPROTOCOL_MSGPACK, SUBPROTOCOL_MSGPACK_BATCH},
+}
+
+// Broadcaster is anything formatted entries can be sent to, the SocketManager when running live
+type Broadcaster interface {
+	Broadcast(data interface{})
+}
+
+// outboundMessage is shared between every client it is queued for, so it is only encoded once
+type outboundMessage struct {
+	data		interface{}
+	critical	bool
+
+	encodeOnce	sync.Once
+	encoded		[]byte
+	encodeErr	error
+
+	msgpackOnce		sync.Once
+	msgpackEncoded	[]byte
+	msgpackErr		error
+}
+
+func (m *outboundMessage) json() ([]byte, error) {
+	m.encodeOnce.Do(func() {
+		m.encoded, m.encodeErr = json.Marshal(m.data)
+	})
+
+	return m.encoded, m.encodeErr
+}
+
+func (m *outboundMessage) msgpack() ([]byte, error) {
+	m.msgpackOnce.Do(func() {
+		m.msgpackEncoded, m.msgpackErr = encodeMsgpack(m.data)
+	})
+
+	return m.msgpackEncoded, m.msgpackErr
+}
+
+// isCritical picks out the messages that are rare and matter, everything high volume can be dropped for a slow client.
+// anything not listed here, such as alerts, counts as critical
+func isCritical(data interface{}) bool {
+	switch data.(type) {
+	case *FormattedCompletedFunctionCall, FormattedTraceFunctionEnterEntry, FormattedTraceFunctionExitEntry, StatPacket, TaskTimelineEntry:
+		return false
+	}
+
+	return true
+}
+
+// ClientStats is what GET /clients reports for every connected websocket
+type ClientStats struct {
+	Id				uint64		`json:"id"`
+	RemoteAddr		string		`json:"remoteAddr"`
+	ConnectedAt		time.Time	`json:"connectedAt"`
+	Queued			int			`json:"queued"`
+	Sent			uint64		`json:"sent"`
+	Dropped			uint64		`json:"dropped"`
+	Subprotocol		string		`json:"subprotocol"`
+	Subscription	*Subscription	`json:"subscription"`
+}
+
+// socketClient owns one websocket, only its writer goroutine ever writes to the connection
+type socketClient struct {
+	id				uint64
+	conn			*websocket.Conn
+	connectedAt		time.Time
+	queue			chan *outboundMessage
+	// closed once the client is unregistered, the queue itself is never closed
+	done			chan struct{}
+	// nil until the client subscribes, which means it gets everything
+	subscription	*Subscription
+
+	// negotiated through the subprotocol when connecting
+	binary			bool
+	batched			bool
+
+	sent			atomic.Uint64
+	dropped			atomic.Uint64
+}
+
+func (c *socketClient) encode(message *outboundMessage) ([]byte, error) {
+	if c.binary {
+		return message.msgpack()
+	}
+
+	return message.json()
+}
+
+func (c *socketClient) write(manager *SocketManager, frame []byte, messages int) bool {
+	messageType := websocket.TextMessage
+	if c.binary {
+		messageType = websocket.BinaryMessage
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(CLIENT_WRITE_TIMEOUT))
+	if err := c.conn.WriteMessage(messageType, frame); err != nil {
+		// assume the client disconnected and clean up
+		manager.Unregister(c.conn)
+		return false
+	}
+	c.sent.Add(uint64(messages))
+
+	return true
 }
 
+func (c *socketClient) writeLoop(manager *SocketManager, batchInterval time.Duration) {
+	if c.batched {
+		c.batchLoop(manager, batchInterval)
+		return
+	}
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case message := <-c.queue:
+			encoded, err := c.encode(message)
+			if err != nil {
+				fmt.Printf("Unable to encode message: %v\n", err)
+				continue
+			}
+
+			if !c.write(manager, encoded, 1) {
+				return
+			}
+		}
+	}
+}
+
+// batchLoop sends whatever has been queued as a single array once every interval, nothing is sent while idle
+func (c *socketClient) batchLoop(manager *SocketManager, batchInterval time.Duration) {
+	ticker := time.NewTicker(max(batchInterval, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		// only take what is queued right now, so a busy processor can't keep a batch open forever
+		pending := len(c.queue)
+		if pending == 0 {
+			continue
+		}
+
+		encodedMessages := make([][]byte, 0, pending)
+		for range pending {
+			var message *outboundMessage
+			// a drop-oldest may have emptied the queue since it was measured
+			select {
+			case message = <-c.queue:
+			default:
+			}
+			if message == nil {
+				break
+			}
+
+			encoded, err := c.encode(message)
+			if err != nil {
+				fmt.Printf("Unable to encode message: %v\n", err)
+				continue
+			}
+			encodedMessages = append(encodedMessages, encoded)
+		}
+
+		if len(encodedMessages) == 0 {
+			continue
+		}
+
+		if !c.write(manager, c.batchFrame(encodedMessages), len(encodedMessages)) {
+			return
+		}
+	}
+}
+
+// batchFrame joins messages that were already encoded on their own into one array
+func (c *socketClient) batchFrame(encodedMessages [][]byte) []byte {
+	frame := bytes.Buffer{}
+
+	if c.binary {
+		writeMsgpackHeader(&frame, len(encodedMessages), 0x90, 0xdc, 0xdd)
+		for _, encoded := range encodedMessages {
+			frame.Write(encoded)
+		}
+		return frame.Bytes()
+	}
+
+	frame.WriteByte('[')
+	for idx, encoded := range encodedMessages {
+		if idx > 0 {
+			frame.WriteByte(',')
+		}
+		frame.Write(encoded)
+	}
+	frame.WriteByte(']')
+
+	return frame.Bytes()
+}
+
+// SocketManager fans every message out to the websocket clients without ever waiting on them,
+// each client gets its own bounded queue and writer goroutine so a slow browser tab can't stall the processor
 type SocketManager struct {
-    clients map[*websocket.Conn]bool
-    lock    sync.Mutex
+	// every client's queue holds this many messages, changes apply to clients that register afterwards
+	QueueSize	int
+	DropPolicy	string
+	// how often clients on a batch subprotocol get a frame, changes apply to clients that register afterwards
+	BatchInterval	time.Duration
+	// new clients are sent a snapshot from here before anything else, nil to start them on the live stream
+	Snapshots		SnapshotProvider
+
+	lock		sync.Mutex
+	clients		map[*websocket.Conn]*socketClient
+	nextId		uint64
 }
 
 func NewSocketManager() *SocketManager {
-    return &SocketManager{
-        clients: make(map[*websocket.Conn]bool),
-    }
+	return &SocketManager{
+		QueueSize: DEFAULT_CLIENT_QUEUE_SIZE,
+		DropPolicy: DROP_OLDEST,
+		BatchInterval: DEFAULT_BATCH_INTERVAL,
+		clients: make(map[*websocket.Conn]*socketClient),
+	}
 }
 
 func (manager *SocketManager) Register(conn *websocket.Conn) {
-    manager.lock.Lock()
-    defer manager.lock.Unlock()
-    manager.clients[conn] = true
+	if manager.Snapshots == nil {
+		manager.register(conn, nil)
+		return
+	}
+
+	manager.Snapshots.Snapshot(func(snapshot []interface{}) {
+		manager.register(conn, snapshot)
+	})
+}
+
+// register adds a client with the snapshot queued ahead of everything broadcast afterwards
+func (manager *SocketManager) register(conn *websocket.Conn, snapshot []interface{}) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	manager.nextId++
+	subprotocol := conn.Subprotocol()
+	client := &socketClient{
+		id: manager.nextId,
+		conn: conn,
+		connectedAt: time.Now(),
+		queue: make(chan *outboundMessage, max(manager.QueueSize, 1)),
+		done: make(chan struct{}),
+		binary: subprotocol == SUBPROTOCOL_MSGPACK || subprotocol == SUBPROTOCOL_MSGPACK_BATCH,
+		batched: subprotocol == SUBPROTOCOL_JSON_BATCH || subprotocol == SUBPROTOCOL_MSGPACK_BATCH,
+	}
+	manager.clients[conn] = client
+
+	for _, data := range snapshot {
+		manager.enqueue(client, &outboundMessage{data: data, critical: true})
+	}
+
+	go client.writeLoop(manager, manager.BatchInterval)
 }
 
 func (manager *SocketManager) Unregister(conn *websocket.Conn) {
-    manager.lock.Lock()
-    defer manager.lock.Unlock()
-    if _, ok := manager.clients[conn]; ok {
-        delete(manager.clients, conn)
-        conn.Close()
-    }
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	manager.unregister(conn)
+}
+
+// unregister assumes the lock is held
+func (manager *SocketManager) unregister(conn *websocket.Conn) {
+	client, ok := manager.clients[conn]
+	if !ok {
+		return
+	}
+
+	delete(manager.clients, conn)
+	close(client.done)
+	conn.Close()
 }
 
 func (manager *SocketManager) Broadcast(data interface{}) {
-    manager.lock.Lock()
-    defer manager.lock.Unlock()
-
-    for conn := range manager.clients {
-        // WriteJSON automatically marshals your structs to JSON
-        err := conn.WriteJSON(data)
-        if err != nil {
-            // If error, assume client disconnected and clean up
-            conn.Close()
-            delete(manager.clients, conn)
-        }
-    }
-}
\ No newline at end of file
+	message := &outboundMessage{
+		data: data,
+		critical: isCritical(data),
+	}
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	// only worked out once some client actually filters
+	var meta *messageMeta
+	for conn, client := range manager.clients {
+		if client.subscription != nil {
+			if meta == nil {
+				described := describe(data)
+				meta = &described
+			}
+
+			if !client.subscription.matches(meta) {
+				continue
+			}
+		}
+
+		if !manager.enqueue(client, message) {
+			fmt.Printf("Disconnecting websocket client %d, it fell %d messages behind\n", client.id, len(client.queue))
+			manager.unregister(conn)
+		}
+	}
+}
+
+// enqueue never blocks, it returns false when the client should be disconnected. assumes the lock is held
+func (manager *SocketManager) enqueue(client *socketClient, message *outboundMessage) bool {
+	select {
+	case client.queue <- message:
+		return true
+	default:
+	}
+
+	switch manager.DropPolicy {
+	case DISCONNECT:
+		return false
+	case DROP_NONCRITICAL:
+		if !message.critical {
+			client.dropped.Add(1)
+			return true
+		}
+
+		client.evictNoncritical()
+		// the writer may have made room while the queue was being searched even if nothing could be evicted
+		select {
+		case client.queue <- message:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// make room by dropping the oldest message, the writer may have made room in the meantime as well
+	select {
+	case <-client.queue:
+		client.dropped.Add(1)
+	default:
+	}
+
+	select {
+	case client.queue <- message:
+	default:
+		client.dropped.Add(1)
+	}
+
+	return true
+}
+
+// evictNoncritical throws away the oldest queued message that isn't critical, if there is one.
+// the queue is taken apart and put back together in order, which is fine since it only happens when a critical message
+// finds the queue full. assumes the manager's lock is held, so the writer is the only other goroutine touching the queue
+func (c *socketClient) evictNoncritical() bool {
+	queued := make([]*outboundMessage, 0, len(c.queue))
+	for drained := false; !drained; {
+		select {
+		case message := <-c.queue:
+			queued = append(queued, message)
+		default:
+			drained = true
+		}
+	}
+
+	evicted := false
+	for _, message := range queued {
+		if !evicted && !message.critical {
+			evicted = true
+			c.dropped.Add(1)
+			continue
+		}
+
+		c.queue <- message
+	}
+
+	return evicted
+}
+
+// Stats reports the queue depth and send / drop counts of every connected client
+func (manager *SocketManager) Stats() []ClientStats {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	stats := make([]ClientStats, 0, len(manager.clients))
+	for _, client := range manager.clients {
+		stats = append(stats, ClientStats{
+			Id: client.id,
+			RemoteAddr: client.conn.RemoteAddr().String(),
+			ConnectedAt: client.connectedAt,
+			Queued: len(client.queue),
+			Sent: client.sent.Load(),
+			Dropped: client.dropped.Load(),
+			Subprotocol: client.conn.Subprotocol(),
+			Subscription: client.subscription,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Id < stats[j].Id })
+
+	return stats
+}
+
+func (manager *SocketManager) HandleClients(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"dropPolicy": manager.DropPolicy,
+		"queueSize": manager.QueueSize,
+		"batchInterval": manager.BatchInterval.String(),
+		"clients": manager.Stats(),
+	})
+}
@@ -0,0 +1,174 @@
+package processing
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+
+	"github.com/gorilla/websocket"
+)
+
+// messages clients can send over /data
+const (
+	CLIENT_SUBSCRIBE = "subscribe"
+	// replies sent back to the client, these carry no traceType so trace entry handlers skip them
+	CLIENT_SUBSCRIBED = "subscribed"
+	CLIENT_ERROR = "error"
+)
+
+// Subscription narrows down what a client is sent, an empty list lets everything through. for example
+//
+//	{"type": "subscribe", "traceTypes": [5], "devices": ["left"]}
+//	{"type": "subscribe", "traceTypes": [4], "cores": [0], "funcPatterns": ["read*", "i2c*"]}
+//
+// filters only apply to messages that carry the field, so a panic still gets through a function pattern
+type Subscription struct {
+	TraceTypes		[]uint32	`json:"traceTypes"`
+	Cores			[]uint32	`json:"cores"`
+	Devices			[]string	`json:"devices"`
+	// shell style patterns such as read* matched against function names
+	FuncPatterns	[]string	`json:"funcPatterns"`
+}
+
+type clientMessage struct {
+	Type			string		`json:"type"`
+	Subscription
+}
+
+type clientReply struct {
+	Type			string			`json:"type"`
+	Message			string			`json:"message,omitempty"`
+	Subscription	*Subscription	`json:"subscription,omitempty"`
+}
+
+// messageMeta holds the fields of a message that subscriptions filter on
+type messageMeta struct {
+	traceType	uint32
+	hasCore		bool
+	coreId		uint32
+	deviceId	string
+	funcName	string
+}
+
+// describe pulls the filterable fields out of a message, types it doesn't know about are round tripped through JSON
+func describe(data interface{}) messageMeta {
+	switch entry := data.(type) {
+	case *FormattedCompletedFunctionCall:
+		return messageMeta{entry.TraceType, true, entry.CoreId, entry.DeviceId, TrimCString(entry.FuncName)}
+	case FormattedTraceFunctionEnterEntry:
+		return messageMeta{entry.TraceType, true, entry.CoreId, entry.DeviceId, TrimCString(entry.FuncName)}
+	case FormattedTraceFunctionExitEntry:
+		return messageMeta{entry.TraceType, true, entry.CoreId, entry.DeviceId, TrimCString(entry.FuncName)}
+	case FormattedTraceFunctionPanicEntry:
+		// the fault function is not a traced call, so panics carry no funcName and get through function patterns
+		return messageMeta{entry.TraceType, true, entry.CoreId, entry.DeviceId, ""}
+	case FormattedTraceFunctionRestartEntry:
+		return messageMeta{entry.TraceType, true, entry.CoreId, entry.DeviceId, ""}
+	case StatPacket:
+		return messageMeta{traceType: entry.TraceType, deviceId: entry.DeviceId}
+	case PacketLossEntry:
+		return messageMeta{entry.TraceType, true, entry.CoreId, entry.DeviceId, ""}
+	case DiagnosticEntry:
+		return messageMeta{entry.TraceType, true, entry.CoreId, entry.DeviceId, ""}
+	case TaskTimelineEntry:
+		return messageMeta{entry.TraceType, true, entry.CoreId, entry.DeviceId, ""}
+	case DeadlineMissEntry:
+		return messageMeta{entry.TraceType, true, entry.CoreId, entry.DeviceId, entry.FuncName}
+	}
+
+	generic := struct {
+		TraceType	uint32		`json:"traceType"`
+		CoreId		*uint32		`json:"coreId"`
+		DeviceId	string		`json:"deviceId"`
+		FuncName	string		`json:"funcName"`
+	}{}
+	if encoded, err := json.Marshal(data); err == nil {
+		json.Unmarshal(encoded, &generic)
+	}
+
+	meta := messageMeta{traceType: generic.TraceType, deviceId: generic.DeviceId, funcName: generic.FuncName}
+	if generic.CoreId != nil {
+		meta.hasCore = true
+		meta.coreId = *generic.CoreId
+	}
+
+	return meta
+}
+
+func (s *Subscription) validate() error {
+	for _, pattern := range s.FuncPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad function pattern %q: %v", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Subscription) matches(meta *messageMeta) bool {
+	if len(s.TraceTypes) > 0 && !slices.Contains(s.TraceTypes, meta.traceType) {
+		return false
+	}
+
+	if len(s.Cores) > 0 && meta.hasCore && !slices.Contains(s.Cores, meta.coreId) {
+		return false
+	}
+
+	if len(s.Devices) > 0 && meta.deviceId != "" && !slices.Contains(s.Devices, meta.deviceId) {
+		return false
+	}
+
+	if len(s.FuncPatterns) > 0 && meta.funcName != "" {
+		for _, pattern := range s.FuncPatterns {
+			if matched, _ := path.Match(pattern, meta.funcName); matched {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// HandleClientMessage acts on a message a client sent over its websocket, replies go out through its queue
+func (manager *SocketManager) HandleClientMessage(conn *websocket.Conn, data []byte) {
+	message := clientMessage{}
+	if err := json.Unmarshal(data, &message); err != nil {
+		manager.reply(conn, clientReply{Type: CLIENT_ERROR, Message: fmt.Sprintf("unable to parse message: %v", err)})
+		return
+	}
+
+	switch message.Type {
+	case CLIENT_SUBSCRIBE:
+		subscription := message.Subscription
+		if err := subscription.validate(); err != nil {
+			manager.reply(conn, clientReply{Type: CLIENT_ERROR, Message: err.Error()})
+			return
+		}
+
+		manager.lock.Lock()
+		if client, ok := manager.clients[conn]; ok {
+			client.subscription = &subscription
+		}
+		manager.lock.Unlock()
+
+		manager.reply(conn, clientReply{Type: CLIENT_SUBSCRIBED, Subscription: &subscription})
+	default:
+		manager.reply(conn, clientReply{Type: CLIENT_ERROR, Message: fmt.Sprintf("unknown message type %q", message.Type)})
+	}
+}
+
+func (manager *SocketManager) reply(conn *websocket.Conn, reply clientReply) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	client, ok := manager.clients[conn]
+	if !ok {
+		return
+	}
+
+	if !manager.enqueue(client, &outboundMessage{data: reply, critical: true}) {
+		manager.unregister(conn)
+	}
+}
@@ -0,0 +1,77 @@
+package processing
+
+import (
+	"strconv"
+
+	"github.com/rs/xid"
+)
+
+// TASK_SWITCH_IN / TASK_SWITCH_OUT come from the FreeRTOS traceTASK_SWITCHED_IN / traceTASK_SWITCHED_OUT hooks,
+// the task handle doubles as the task ID that call stacks are keyed by
+type TraceTaskSwitchEntry struct {
+	TraceFunctionGeneralEntry
+	TaskHandle		uint32
+	Priority		uint32
+	TaskName		[16]byte
+}
+
+// TaskTimelineEntry is one stretch of time a task spent running on a core
+type TaskTimelineEntry struct {
+	TraceType		uint32		`json:"traceType"`
+	DeviceId		string		`json:"deviceId"`
+	CoreId			uint32		`json:"coreId"`
+	TaskId			uint32		`json:"taskId"`
+	TaskName		string		`json:"taskName"`
+	Priority		uint32		`json:"priority"`
+	StartTime		string		`json:"startTime"`
+	EndTime			string		`json:"endTime"`
+	PacketId		string		`json:"packetId"`
+}
+
+type runningTask struct {
+	taskId			uint32
+	name			string
+	priority		uint32
+	switchedInAt	int64
+}
+
+func (p *Processor) processTaskSwitch(d *deviceState, entry *TraceTaskSwitchEntry) {
+	timestamp := d.timeKeeper.GetTimestampToSend(entry.Timestamp)
+
+	// a switch in without a switch out means the previous one was lost, close it here instead
+	p.endTaskSlice(d, entry.CoreId, timestamp)
+
+	if entry.TraceType == TASK_SWITCH_OUT {
+		return
+	}
+
+	name := TrimCString(string(entry.TaskName[:]))
+	d.taskNames[entry.TaskHandle] = name
+	d.runningTasks[entry.CoreId] = &runningTask{
+		taskId: entry.TaskHandle,
+		name: name,
+		priority: entry.Priority,
+		switchedInAt: timestamp,
+	}
+}
+
+// endTaskSlice sends out the timeline slice for whatever task is running on a core
+func (p *Processor) endTaskSlice(d *deviceState, coreId uint32, endTime int64) {
+	task, ok := d.runningTasks[coreId]
+	if !ok {
+		return
+	}
+	delete(d.runningTasks, coreId)
+
+	p.Broadcaster.Broadcast(TaskTimelineEntry{
+		TraceType: TASK_TIMELINE,
+		DeviceId: d.deviceId,
+		CoreId: coreId,
+		TaskId: task.taskId,
+		TaskName: task.name,
+		Priority: task.priority,
+		StartTime: strconv.FormatInt(task.switchedInAt, 10),
+		EndTime: strconv.FormatInt(endTime, 10),
+		PacketId: xid.New().String(),
+	})
+}
@@ -1,18 +1,17 @@
 package processing
 
-import (
-	"time"
-)
-
 type TimeKeeper struct {
 	ProgStartTime 	int64
 	BoardStartTime	int64
+	// microseconds since the epoch, the wall clock when live and the recorded receive time when reading a session
+	now				func() int64
 }
 
-func NewTimeKeeper() *TimeKeeper {
+func NewTimeKeeper(now func() int64) *TimeKeeper {
 	return &TimeKeeper{
-		ProgStartTime: time.Now().UnixMicro(),
+		ProgStartTime: now(),
 		BoardStartTime: 0,
+		now: now,
 	}
 }
 
@@ -27,7 +26,12 @@ func (t *TimeKeeper) GetTimestampToSend(boardTime uint32) int64 {
 	return t.ProgStartTime + (expandedBoardTime - t.BoardStartTime)
 }
 
+// Now is the current time on the same scale as the timestamps handed out
+func (t *TimeKeeper) Now() int64 {
+	return t.now()
+}
+
 func (t *TimeKeeper) HandleBoardReset() {
 	t.BoardStartTime = 0
-	t.ProgStartTime = time.Now().UnixMicro()
+	t.ProgStartTime = t.now()
 }
\ No newline at end of file
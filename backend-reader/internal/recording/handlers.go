@@ -0,0 +1,39 @@
+package recording
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func (r *Recorder) HandleStart(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "use POST to start recording", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.Start(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	r.HandleStatus(w, req)
+}
+
+func (r *Recorder) HandleStop(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "use POST to stop recording", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.Stop(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	r.HandleStatus(w, req)
+}
+
+func (r *Recorder) HandleStatus(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.Status())
+}
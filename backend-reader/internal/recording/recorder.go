@@ -0,0 +1,211 @@
+package recording
+
+import (
+	tracereader "RP-UCLA/backend-reader/internal/traceReader"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	SESSION_FILE_PREFIX = "session-"
+	SESSION_TIME_FORMAT = "20060102-150405"
+
+	// how many taken sequence numbers openFile skips before giving up
+	MAX_NAME_ATTEMPTS = 1000
+)
+
+type RecorderStatus struct {
+	Recording		bool		`json:"recording"`
+	File			string		`json:"file"`
+	StartedAt		string		`json:"startedAt"`
+	BytesWritten	int64		`json:"bytesWritten"`
+	PacketsWritten	int64		`json:"packetsWritten"`
+	FilesWritten	int			`json:"filesWritten"`
+}
+
+// Recorder tees raw packets into session files on disk, rotating to a new file
+// once the current one grows past MaxBytes or has been open for longer than MaxDuration
+type Recorder struct {
+	mu				sync.Mutex
+	Dir				string
+	MaxBytes		int64
+	MaxDuration		time.Duration
+
+	file			*os.File
+	fileOpenedAt	time.Time
+	fileBytes		int64
+	fileSeq			int
+
+	startedAt		time.Time
+	bytesWritten	int64
+	packetsWritten	int64
+	filesWritten	int
+}
+
+func NewRecorder(dir string, maxBytes int64, maxDuration time.Duration) *Recorder {
+	return &Recorder{
+		Dir: dir,
+		MaxBytes: maxBytes,
+		MaxDuration: maxDuration,
+	}
+}
+
+func (r *Recorder) Start() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file != nil {
+		return fmt.Errorf("already recording to %s", r.file.Name())
+	}
+
+	if err := os.MkdirAll(r.Dir, 0o755); err != nil {
+		return fmt.Errorf("unable to create recording directory %s: %v", r.Dir, err)
+	}
+
+	r.startedAt = time.Now()
+	r.bytesWritten = 0
+	r.packetsWritten = 0
+	r.filesWritten = 0
+	r.fileSeq = 0
+
+	return r.openFile()
+}
+
+func (r *Recorder) Stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return fmt.Errorf("not recording")
+	}
+
+	return r.closeFile()
+}
+
+func (r *Recorder) Status() RecorderStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := RecorderStatus{
+		Recording: r.file != nil,
+		BytesWritten: r.bytesWritten,
+		PacketsWritten: r.packetsWritten,
+		FilesWritten: r.filesWritten,
+	}
+
+	if r.file != nil {
+		status.File = r.file.Name()
+		status.StartedAt = r.startedAt.Format(time.RFC3339)
+	}
+
+	return status
+}
+
+// Record appends a packet to the current session file, doing nothing if the recorder is stopped
+func (r *Recorder) Record(packet tracereader.RawPacket) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	if r.shouldRotate() {
+		if err := r.closeFile(); err != nil {
+			return err
+		}
+		if err := r.openFile(); err != nil {
+			return err
+		}
+	}
+
+	record, err := encodeRecord(packet)
+	if err != nil {
+		return err
+	}
+
+	n, err := r.file.Write(record)
+	r.fileBytes += int64(n)
+	r.bytesWritten += int64(n)
+	if err != nil {
+		return fmt.Errorf("unable to write to session file %s: %v", r.file.Name(), err)
+	}
+
+	r.packetsWritten++
+
+	return nil
+}
+
+// Tee records every packet coming in before handing it on to the processor
+func (r *Recorder) Tee(in <-chan tracereader.RawPacket, out chan<- tracereader.RawPacket) {
+	for packet := range in {
+		// resets are not part of what came off the board
+		if !packet.Reset {
+			if err := r.Record(packet); err != nil {
+				log.Printf("Unable to record packet: %v\n", err)
+			}
+		}
+
+		out <- packet
+	}
+}
+
+func (r *Recorder) shouldRotate() bool {
+	if r.MaxBytes > 0 && r.fileBytes >= r.MaxBytes {
+		return true
+	}
+
+	return r.MaxDuration > 0 && time.Since(r.fileOpenedAt) >= r.MaxDuration
+}
+
+// openFile assumes the lock is held
+func (r *Recorder) openFile() error {
+	now := time.Now()
+
+	// names only go down to the second, so a recording stopped and started again within the same second
+	// (or a second recorder on the same directory) would hit an existing file, skip ahead to a free sequence number
+	var file *os.File
+	var path string
+	for attempt := 0; ; attempt++ {
+		r.fileSeq++
+		name := fmt.Sprintf("%s%s-%03d%s", SESSION_FILE_PREFIX, r.startedAt.Format(SESSION_TIME_FORMAT), r.fileSeq, FILE_EXTENSION)
+		path = filepath.Join(r.Dir, name)
+
+		var err error
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND|os.O_EXCL, 0o644)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) || attempt >= MAX_NAME_ATTEMPTS {
+			return fmt.Errorf("unable to create session file %s: %v", path, err)
+		}
+	}
+
+	n, err := writeSessionHeader(file, now)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("unable to write session header to %s: %v", path, err)
+	}
+
+	r.file = file
+	r.fileOpenedAt = now
+	r.fileBytes = n
+	r.bytesWritten += n
+	r.filesWritten++
+
+	log.Printf("Recording session to %s\n", path)
+
+	return nil
+}
+
+// closeFile assumes the lock is held
+func (r *Recorder) closeFile() error {
+	err := r.file.Close()
+	r.file = nil
+
+	return err
+}
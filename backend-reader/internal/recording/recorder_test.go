@@ -0,0 +1,38 @@
+package recording
+
+import (
+	tracereader "RP-UCLA/backend-reader/internal/traceReader"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// stopping and starting within the same second used to fail on the existing session file
+func TestRecorderRestartWithinASecond(t *testing.T) {
+	dir := t.TempDir()
+	recorder := NewRecorder(dir, 0, 0)
+
+	files := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		if err := recorder.Start(); err != nil {
+			t.Fatalf("start %d: %v", i, err)
+		}
+		files[recorder.Status().File] = true
+
+		if err := recorder.Record(tracereader.RawPacket{Source: "board", ReceivedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := recorder.Stop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(files) != 3 {
+		t.Errorf("recorded to %v, want 3 different files", files)
+	}
+
+	sessions, err := filepath.Glob(filepath.Join(dir, "*"+FILE_EXTENSION))
+	if err != nil || len(sessions) != 3 {
+		t.Errorf("found sessions %v (%v), want 3", sessions, err)
+	}
+}
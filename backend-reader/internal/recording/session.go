@@ -0,0 +1,168 @@
+package recording
+
+import (
+	tracereader "RP-UCLA/backend-reader/internal/traceReader"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+/*
+Session files are append only and laid out as follows (everything little endian):
+
+File header
+	magic			[4]byte		"HRMS"
+	version			uint16
+	_				uint16
+	createdAt		int64		unix microseconds
+
+Followed by any number of records
+	receivedAt		int64		host receive time in unix microseconds
+	deviceIdLen		uint8
+	deviceId		[deviceIdLen]byte
+	packet			[RAW_PACKET_SIZE]byte
+*/
+
+const (
+	FORMAT_VERSION = 1
+	FILE_EXTENSION = ".hrec"
+	MAX_DEVICE_ID_LEN = 255
+)
+
+var SESSION_MAGIC = [4]byte{'H', 'R', 'M', 'S'}
+
+type sessionHeader struct {
+	Magic		[4]byte
+	Version		uint16
+	_			uint16
+	CreatedAt	int64
+}
+
+type recordHeader struct {
+	ReceivedAt	int64
+	DeviceIdLen	uint8
+}
+
+func writeSessionHeader(w io.Writer, createdAt time.Time) (int64, error) {
+	header := sessionHeader{
+		Magic: SESSION_MAGIC,
+		Version: FORMAT_VERSION,
+		CreatedAt: createdAt.UnixMicro(),
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return 0, err
+	}
+
+	return int64(binary.Size(header)), nil
+}
+
+// encodeRecord serialises a packet into the on disk record layout
+func encodeRecord(packet tracereader.RawPacket) ([]byte, error) {
+	if len(packet.Source) > MAX_DEVICE_ID_LEN {
+		return nil, fmt.Errorf("device ID %q is longer than %d bytes", packet.Source, MAX_DEVICE_ID_LEN)
+	}
+
+	header := recordHeader{
+		ReceivedAt: packet.ReceivedAt.UnixMicro(),
+		DeviceIdLen: uint8(len(packet.Source)),
+	}
+
+	record := make([]byte, 0, binary.Size(header) + len(packet.Source) + tracereader.RAW_PACKET_SIZE)
+	record, err := binary.Append(record, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+	record = append(record, packet.Source...)
+	record = append(record, packet.Data[:]...)
+
+	return record, nil
+}
+
+// SessionReader reads packets back out of a session file in the order they were recorded
+type SessionReader struct {
+	file		*os.File
+	reader		*bufio.Reader
+	CreatedAt	time.Time
+	Version		uint16
+}
+
+func OpenSession(path string) (*SessionReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SessionReader{
+		file: file,
+		reader: bufio.NewReader(file),
+	}
+
+	if err := s.readHeader(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a valid session file: %v", path, err)
+	}
+
+	return s, nil
+}
+
+func (s *SessionReader) readHeader() error {
+	header := sessionHeader{}
+	if err := binary.Read(s.reader, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+
+	if header.Magic != SESSION_MAGIC {
+		return fmt.Errorf("bad magic %v", header.Magic)
+	}
+
+	if header.Version != FORMAT_VERSION {
+		return fmt.Errorf("unsupported format version %d", header.Version)
+	}
+
+	s.Version = header.Version
+	s.CreatedAt = time.UnixMicro(header.CreatedAt)
+
+	return nil
+}
+
+// Next returns the next recorded packet, or io.EOF once the session has been fully read
+func (s *SessionReader) Next() (tracereader.RawPacket, error) {
+	packet := tracereader.RawPacket{}
+	header := recordHeader{}
+
+	if err := binary.Read(s.reader, binary.LittleEndian, &header); err != nil {
+		return packet, err
+	}
+
+	deviceId := make([]byte, header.DeviceIdLen)
+	if _, err := io.ReadFull(s.reader, deviceId); err != nil {
+		return packet, truncated(err)
+	}
+
+	if _, err := io.ReadFull(s.reader, packet.Data[:]); err != nil {
+		return packet, truncated(err)
+	}
+
+	packet.Source = string(deviceId)
+	packet.ReceivedAt = time.UnixMicro(header.ReceivedAt)
+
+	return packet, nil
+}
+
+func (s *SessionReader) Close() error {
+	return s.file.Close()
+}
+
+// a record cut off half way means the recorder was killed mid write, which is not a clean EOF
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
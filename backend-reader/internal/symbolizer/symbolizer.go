@@ -0,0 +1,200 @@
+package symbolizer
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+type Symbol struct {
+	Function	string
+	File		string
+	Line		int
+}
+
+type lineRow struct {
+	address		uint64
+	file		string
+	line		int
+	// end of sequence rows mark the first address past a contiguous block of code
+	endSequence	bool
+}
+
+type funcRange struct {
+	low		uint64
+	high	uint64
+	name	string
+}
+
+// Symbolizer maps program counters back to functions and source lines using the firmware ELF
+type Symbolizer struct {
+	Path	string
+	lines	[]lineRow
+	funcs	[]funcRange
+}
+
+func Load(path string) (*Symbolizer, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := Parse(bytes.NewReader(contents))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load %s: %v", path, err)
+	}
+	s.Path = path
+
+	return s, nil
+}
+
+func Parse(r io.ReaderAt) (*Symbolizer, error) {
+	file, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	s := &Symbolizer{
+		lines: make([]lineRow, 0),
+		funcs: make([]funcRange, 0),
+	}
+
+	debugInfo, err := file.DWARF()
+	if err != nil {
+		return nil, fmt.Errorf("no usable DWARF debug info, was the firmware built with -g? %v", err)
+	}
+
+	if err := s.loadDWARF(debugInfo); err != nil {
+		return nil, err
+	}
+
+	// the symbol table covers functions without debug info, such as precompiled ESP-IDF libraries
+	s.loadSymbolTable(file)
+
+	sort.SliceStable(s.lines, func(i, j int) bool {
+		if s.lines[i].address != s.lines[j].address {
+			return s.lines[i].address < s.lines[j].address
+		}
+		// a sequence can start at the same address another one ends, the start has to win the lookup
+		return s.lines[i].endSequence && !s.lines[j].endSequence
+	})
+	sort.SliceStable(s.funcs, func(i, j int) bool { return s.funcs[i].low < s.funcs[j].low })
+
+	return s, nil
+}
+
+func (s *Symbolizer) loadDWARF(debugInfo *dwarf.Data) error {
+	reader := debugInfo.Reader()
+
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			return fmt.Errorf("unable to read DWARF entries: %v", err)
+		}
+		if entry == nil {
+			return nil
+		}
+
+		switch entry.Tag {
+		case dwarf.TagCompileUnit:
+			if err := s.loadLineTable(debugInfo, entry); err != nil {
+				return err
+			}
+		case dwarf.TagSubprogram:
+			name, ok := entry.Val(dwarf.AttrName).(string)
+			if !ok {
+				continue
+			}
+
+			ranges, err := debugInfo.Ranges(entry)
+			if err != nil {
+				continue
+			}
+
+			for _, addrRange := range ranges {
+				s.funcs = append(s.funcs, funcRange{low: addrRange[0], high: addrRange[1], name: name})
+			}
+		}
+	}
+}
+
+func (s *Symbolizer) loadLineTable(debugInfo *dwarf.Data, compileUnit *dwarf.Entry) error {
+	lineReader, err := debugInfo.LineReader(compileUnit)
+	if err != nil {
+		return fmt.Errorf("unable to read line table: %v", err)
+	}
+	// compile units without any code have no line table
+	if lineReader == nil {
+		return nil
+	}
+
+	lineEntry := dwarf.LineEntry{}
+	for {
+		err := lineReader.Next(&lineEntry)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read line table: %v", err)
+		}
+
+		row := lineRow{
+			address: lineEntry.Address,
+			line: lineEntry.Line,
+			endSequence: lineEntry.EndSequence,
+		}
+		if lineEntry.File != nil {
+			row.file = lineEntry.File.Name
+		}
+
+		s.lines = append(s.lines, row)
+	}
+}
+
+func (s *Symbolizer) loadSymbolTable(file *elf.File) {
+	symbols, err := file.Symbols()
+	if err != nil {
+		return
+	}
+
+	for _, symbol := range symbols {
+		if elf.ST_TYPE(symbol.Info) != elf.STT_FUNC || symbol.Size == 0 {
+			continue
+		}
+
+		s.funcs = append(s.funcs, funcRange{low: symbol.Value, high: symbol.Value + symbol.Size, name: symbol.Name})
+	}
+}
+
+// Lookup finds the function and source line containing pc, ok is false if nothing is known about the address
+func (s *Symbolizer) Lookup(pc uint32) (Symbol, bool) {
+	address := uint64(pc)
+	symbol := Symbol{}
+	found := false
+
+	// the last row at or before the address holds its line, unless that row ends a sequence
+	idx := sort.Search(len(s.lines), func(i int) bool { return s.lines[i].address > address }) - 1
+	if idx >= 0 && !s.lines[idx].endSequence {
+		symbol.File = s.lines[idx].file
+		symbol.Line = s.lines[idx].line
+		found = true
+	}
+
+	// prefer the innermost (latest starting) function containing the address, in case ranges are nested
+	idx = sort.Search(len(s.funcs), func(i int) bool { return s.funcs[i].low > address }) - 1
+	for ; idx >= 0; idx-- {
+		if address < s.funcs[idx].high {
+			symbol.Function = s.funcs[idx].name
+			found = true
+			break
+		}
+	}
+
+	return symbol, found
+}
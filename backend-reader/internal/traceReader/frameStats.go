@@ -0,0 +1,37 @@
+package tracereader
+
+import "sync/atomic"
+
+// FrameStats counts how well a reader is keeping in sync with its byte stream
+type FrameStats struct {
+	// frames that made it through to the message queue
+	Frames			uint64	`json:"frames"`
+	// candidate frames with a valid header whose checksum did not match
+	Corrupted		uint64	`json:"corrupted"`
+	// frames thrown away for any other reason, such as a bad length or terminator
+	Dropped			uint64	`json:"dropped"`
+	// bytes skipped while hunting for the start of the next frame
+	BytesSkipped	uint64	`json:"bytesSkipped"`
+}
+
+// FrameStatsReporter is implemented by readers that keep FrameStats
+type FrameStatsReporter interface {
+	FrameStats() FrameStats
+}
+
+// FrameCounters is the lock free version of FrameStats, safe to bump from the reader while being read elsewhere
+type FrameCounters struct {
+	Frames			atomic.Uint64
+	Corrupted		atomic.Uint64
+	Dropped			atomic.Uint64
+	BytesSkipped	atomic.Uint64
+}
+
+func (c *FrameCounters) Snapshot() FrameStats {
+	return FrameStats{
+		Frames: c.Frames.Load(),
+		Corrupted: c.Corrupted.Load(),
+		Dropped: c.Dropped.Load(),
+		BytesSkipped: c.BytesSkipped.Load(),
+	}
+}
@@ -0,0 +1,138 @@
+package framing
+
+import (
+	tracereader "RP-UCLA/backend-reader/internal/traceReader"
+	"bytes"
+	"encoding/binary"
+)
+
+/*
+Framed packets are laid out as
+
+	0xA5 0x5A		start of frame magic
+	length			uint8, always RAW_PACKET_SIZE for now
+	payload			[length]byte
+	crc				uint16 little endian, CRC-16/CCITT-FALSE over the length byte and payload
+
+The magic can legitimately show up inside a payload, so a bad length or checksum only
+skips the first magic byte and the search resumes from there, never from the end of the bad frame
+*/
+
+const (
+	SOF_0 = 0xA5
+	SOF_1 = 0x5A
+
+	HEADER_SIZE = 3
+	CRC_SIZE = 2
+	FRAME_SIZE = HEADER_SIZE + tracereader.RAW_PACKET_SIZE + CRC_SIZE
+
+	CRC16_POLY = 0x1021
+	CRC16_INIT = 0xFFFF
+)
+
+var startOfFrame = []byte{SOF_0, SOF_1}
+
+// CRC16 computes CRC-16/CCITT-FALSE (poly 0x1021, init 0xFFFF, no reflection or final xor)
+func CRC16(data []byte) uint16 {
+	crc := uint16(CRC16_INIT)
+
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for range 8 {
+			if crc & 0x8000 != 0 {
+				crc = crc << 1 ^ CRC16_POLY
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// Encode wraps a packet in a frame, mirroring what the firmware sends
+func Encode(packet [tracereader.RAW_PACKET_SIZE]byte) []byte {
+	frame := make([]byte, 0, FRAME_SIZE)
+	frame = append(frame, SOF_0, SOF_1, tracereader.RAW_PACKET_SIZE)
+	frame = append(frame, packet[:]...)
+
+	return binary.LittleEndian.AppendUint16(frame, CRC16(frame[2:]))
+}
+
+// Decoder pulls frames out of an arbitrarily chunked byte stream
+type Decoder struct {
+	buf			[]byte
+	Counters	*tracereader.FrameCounters
+}
+
+func NewDecoder(counters *tracereader.FrameCounters) *Decoder {
+	return &Decoder{
+		buf: make([]byte, 0, FRAME_SIZE * 4),
+		Counters: counters,
+	}
+}
+
+// Write adds freshly read bytes to the decoder
+func (d *Decoder) Write(data []byte) {
+	d.buf = append(d.buf, data...)
+}
+
+// Next returns the next valid packet, ok is false once more bytes are needed
+func (d *Decoder) Next() (packet [tracereader.RAW_PACKET_SIZE]byte, ok bool) {
+	for {
+		idx := bytes.Index(d.buf, startOfFrame)
+		if idx < 0 {
+			// hold on to a trailing first magic byte, its partner may be in the next read
+			keep := 0
+			if len(d.buf) > 0 && d.buf[len(d.buf) - 1] == SOF_0 {
+				keep = 1
+			}
+			d.skip(len(d.buf) - keep)
+			return packet, false
+		}
+		d.skip(idx)
+
+		if len(d.buf) < HEADER_SIZE {
+			return packet, false
+		}
+
+		if d.buf[2] != tracereader.RAW_PACKET_SIZE {
+			d.Counters.Dropped.Add(1)
+			d.skip(1)
+			continue
+		}
+
+		if len(d.buf) < FRAME_SIZE {
+			return packet, false
+		}
+
+		expectedCRC := binary.LittleEndian.Uint16(d.buf[HEADER_SIZE + tracereader.RAW_PACKET_SIZE : FRAME_SIZE])
+		if CRC16(d.buf[2 : HEADER_SIZE + tracereader.RAW_PACKET_SIZE]) != expectedCRC {
+			d.Counters.Corrupted.Add(1)
+			d.skip(1)
+			continue
+		}
+
+		copy(packet[:], d.buf[HEADER_SIZE : HEADER_SIZE + tracereader.RAW_PACKET_SIZE])
+		d.consume(FRAME_SIZE)
+		d.Counters.Frames.Add(1)
+
+		return packet, true
+	}
+}
+
+// skip throws bytes away while resyncing
+func (d *Decoder) skip(n int) {
+	if n <= 0 {
+		return
+	}
+
+	d.Counters.BytesSkipped.Add(uint64(n))
+	d.consume(n)
+}
+
+func (d *Decoder) consume(n int) {
+	// shift down rather than reslicing so the buffer does not creep along its backing array forever
+	remaining := copy(d.buf, d.buf[n:])
+	d.buf = d.buf[:remaining]
+}
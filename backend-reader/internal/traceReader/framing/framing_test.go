@@ -0,0 +1,138 @@
+package framing
+
+import (
+	tracereader "RP-UCLA/backend-reader/internal/traceReader"
+	"slices"
+	"testing"
+)
+
+// testPacket fills a packet with fill, then writes extra over the start of it
+func testPacket(fill byte, extra ...byte) [tracereader.RAW_PACKET_SIZE]byte {
+	packet := [tracereader.RAW_PACKET_SIZE]byte{}
+	for idx := range packet {
+		packet[idx] = fill
+	}
+	copy(packet[:], extra)
+
+	return packet
+}
+
+func corruptCRC(frame []byte) []byte {
+	corrupted := slices.Clone(frame)
+	corrupted[len(corrupted) - 1] ^= 0xFF
+
+	return corrupted
+}
+
+func TestCRC16(t *testing.T) {
+	// the standard check value for CRC-16/CCITT-FALSE
+	if crc := CRC16([]byte("123456789")); crc != 0x29B1 {
+		t.Fatalf("CRC16(\"123456789\") = %#04x, want 0x29b1", crc)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	first := testPacket(0x11)
+	second := testPacket(0x22)
+	// a payload that looks like the start of another frame
+	withMagic := testPacket(0x33, SOF_0, SOF_1, tracereader.RAW_PACKET_SIZE, SOF_0, SOF_1)
+
+	tests := []struct {
+		name			string
+		chunks			[][]byte
+		want			[][tracereader.RAW_PACKET_SIZE]byte
+		wantCorrupted	uint64
+		wantDropped		uint64
+	}{
+		{
+			name: "single frame",
+			chunks: [][]byte{Encode(first)},
+			want: [][tracereader.RAW_PACKET_SIZE]byte{first},
+		},
+		{
+			name: "back to back frames",
+			chunks: [][]byte{slices.Concat(Encode(first), Encode(second))},
+			want: [][tracereader.RAW_PACKET_SIZE]byte{first, second},
+		},
+		{
+			name: "garbage before a frame",
+			chunks: [][]byte{slices.Concat([]byte{0x00, SOF_0, 0x01, SOF_1}, Encode(first))},
+			want: [][tracereader.RAW_PACKET_SIZE]byte{first},
+		},
+		{
+			name: "corrupt crc is skipped",
+			chunks: [][]byte{slices.Concat(corruptCRC(Encode(first)), Encode(second))},
+			want: [][tracereader.RAW_PACKET_SIZE]byte{second},
+			wantCorrupted: 1,
+		},
+		{
+			name: "bad length is skipped",
+			chunks: [][]byte{slices.Concat([]byte{SOF_0, SOF_1, 0x10}, Encode(second))},
+			want: [][tracereader.RAW_PACKET_SIZE]byte{second},
+			wantDropped: 1,
+		},
+		{
+			name: "truncated frame waits for the rest",
+			chunks: [][]byte{Encode(first)[:40]},
+			want: nil,
+		},
+		{
+			name: "frame split across reads",
+			chunks: [][]byte{Encode(first)[:1], Encode(first)[1:2], Encode(first)[2:50], Encode(first)[50:]},
+			want: [][tracereader.RAW_PACKET_SIZE]byte{first},
+		},
+		{
+			name: "truncated frame followed by a whole one",
+			chunks: [][]byte{slices.Concat(Encode(first)[:40], Encode(second))},
+			want: [][tracereader.RAW_PACKET_SIZE]byte{second},
+			wantCorrupted: 1,
+		},
+		{
+			name: "magic inside a payload",
+			chunks: [][]byte{slices.Concat(Encode(withMagic), Encode(first))},
+			want: [][tracereader.RAW_PACKET_SIZE]byte{withMagic, first},
+		},
+		{
+			name: "resync from magic inside a corrupt payload",
+			chunks: [][]byte{slices.Concat(corruptCRC(Encode(withMagic)), Encode(second))},
+			want: [][tracereader.RAW_PACKET_SIZE]byte{second},
+			// the frame itself, then the fake frame inside it, then the fake one's bad length
+			wantCorrupted: 2,
+			wantDropped: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counters := tracereader.FrameCounters{}
+			decoder := NewDecoder(&counters)
+
+			var got [][tracereader.RAW_PACKET_SIZE]byte
+			for _, chunk := range test.chunks {
+				decoder.Write(chunk)
+				for {
+					packet, ok := decoder.Next()
+					if !ok {
+						break
+					}
+					got = append(got, packet)
+				}
+			}
+
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %d packets %x, want %d packets %x", len(got), got, len(test.want), test.want)
+			}
+
+			stats := counters.Snapshot()
+			if stats.Frames != uint64(len(test.want)) {
+				t.Errorf("Frames = %d, want %d", stats.Frames, len(test.want))
+			}
+			if stats.Corrupted != test.wantCorrupted {
+				t.Errorf("Corrupted = %d, want %d", stats.Corrupted, test.wantCorrupted)
+			}
+			if stats.Dropped != test.wantDropped {
+				t.Errorf("Dropped = %d, want %d", stats.Dropped, test.wantDropped)
+			}
+		})
+	}
+}
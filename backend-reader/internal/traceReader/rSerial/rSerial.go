@@ -1,9 +1,12 @@
 package rSerial
 
 import (
+	tracereader "RP-UCLA/backend-reader/internal/traceReader"
+	"RP-UCLA/backend-reader/internal/traceReader/framing"
 	"bytes"
 	"fmt"
 	"log"
+	"time"
 
 	"go.bug.st/serial"
 )
@@ -11,16 +14,31 @@ import (
 const (
 	RAW_PACKET_SIZE = 72
 	RAW_MESSAGE_SIZE = RAW_PACKET_SIZE + 2
+	READ_CHUNK_SIZE = 256
+)
+
+// framing modes
+const (
+	// packets terminated by the stop sequence, the original protocol
+	FRAMING_STOP_SEQUENCE = "stop-sequence"
+	// magic, length, payload and CRC16, see the framing package
+	FRAMING_CRC16 = "crc16"
 )
 
 type RSerial struct {
 	serial.Port
-	MessageQueue chan<- [RAW_PACKET_SIZE]byte
+	MessageQueue chan<- tracereader.RawPacket
+	Source		string
 	PortName 	string
 	StopSequence []byte
+	Framing		string
+
+	counters	tracereader.FrameCounters
+	decoder		*framing.Decoder
+	readChunk	[]byte
 }
 
-func NewRSerial(portName string, baudrate int, stopSequence []byte, messageQueue chan<- [RAW_PACKET_SIZE]byte) *RSerial {
+func NewRSerial(source string, portName string, baudrate int, stopSequence []byte, framingMode string, messageQueue chan<- tracereader.RawPacket) *RSerial {
 	mode := &serial.Mode{
 		BaudRate: baudrate,
 	}
@@ -30,12 +48,25 @@ func NewRSerial(portName string, baudrate int, stopSequence []byte, messageQueue
 		log.Fatalf("Unable to open serial port %s: %v\n", portName, err)
 	}
 
-	return &RSerial{
+	r := &RSerial{
 		Port: port,
 		MessageQueue: messageQueue,
+		Source: source,
 		PortName: portName,
 		StopSequence: stopSequence,
+		Framing: framingMode,
+	}
+
+	if framingMode == FRAMING_CRC16 {
+		r.decoder = framing.NewDecoder(&r.counters)
+		r.readChunk = make([]byte, READ_CHUNK_SIZE)
 	}
+
+	return r
+}
+
+func (r *RSerial) FrameStats() tracereader.FrameStats {
+	return r.counters.Snapshot()
 }
 
 func (r *RSerial) sync() {
@@ -55,6 +86,10 @@ func (r *RSerial) sync() {
 }
 
 func (r *RSerial) ReadPacket() error {
+	if r.decoder != nil {
+		return r.readFramedPacket()
+	}
+
 	count := 0
 	tempBuf := [RAW_MESSAGE_SIZE]byte{}
 
@@ -67,17 +102,52 @@ func (r *RSerial) ReadPacket() error {
 		count += n
 	}
 
-	if !bytes.Equal(tempBuf[len(tempBuf) - 2 : ], []byte{'\r', '\n'}) {
+	if !bytes.Equal(tempBuf[len(tempBuf) - 2 : ], r.StopSequence) {
+		r.counters.Dropped.Add(1)
 		return fmt.Errorf("control sequence at the end incorrect, %v", tempBuf[len(tempBuf) - 2 : ])
 	}
 
-	r.MessageQueue <- [RAW_PACKET_SIZE]byte(tempBuf[:len(tempBuf) - 2])
+	r.MessageQueue <- tracereader.RawPacket{
+		Source: r.Source,
+		ReceivedAt: time.Now(),
+		Data: [RAW_PACKET_SIZE]byte(tempBuf[:len(tempBuf) - 2]),
+	}
+	r.counters.Frames.Add(1)
 
 	return nil
 }
 
+// readFramedPacket keeps reading until the decoder hands back a valid frame, the decoder resyncs on its own
+func (r *RSerial) readFramedPacket() error {
+	for {
+		if packet, ok := r.decoder.Next(); ok {
+			r.MessageQueue <- tracereader.RawPacket{
+				Source: r.Source,
+				ReceivedAt: time.Now(),
+				Data: packet,
+			}
+			return nil
+		}
+
+		n, err := r.Read(r.readChunk)
+		if err != nil {
+			return fmt.Errorf("error in reader, %v", err)
+		}
+		r.decoder.Write(r.readChunk[:n])
+	}
+}
+
 func (r *RSerial) Run() {
 	r.ResetInputBuffer()
+
+	if r.decoder != nil {
+		for {
+			if err := r.ReadPacket(); err != nil {
+				log.Printf("%v\n", err)
+			}
+		}
+	}
+
 	r.sync()
 
 	for {
@@ -0,0 +1,101 @@
+package tracereader
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+type ReaderStatus struct {
+	Source		string		`json:"source"`
+	// nil for readers that do not track framing
+	FrameStats	*FrameStats	`json:"frameStats"`
+}
+
+// Registry keeps track of every reader feeding the message queue, keyed by its source identifier
+type Registry struct {
+	mu		sync.Mutex
+	readers	map[string]TraceReader
+	// preserve registration order so logs and listings are stable
+	sources	[]string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		readers: make(map[string]TraceReader),
+		sources: make([]string, 0),
+	}
+}
+
+func (r *Registry) Register(source string, reader TraceReader) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if source == "" {
+		return fmt.Errorf("reader source cannot be empty")
+	}
+
+	if _, ok := r.readers[source]; ok {
+		return fmt.Errorf("a reader with source %q is already registered", source)
+	}
+
+	r.readers[source] = reader
+	r.sources = append(r.sources, source)
+
+	return nil
+}
+
+func (r *Registry) Sources() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sources := make([]string, len(r.sources))
+	copy(sources, r.sources)
+
+	return sources
+}
+
+func (r *Registry) Get(source string) (TraceReader, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reader, ok := r.readers[source]
+	return reader, ok
+}
+
+// RunAll starts every registered reader on its own goroutine
+func (r *Registry) RunAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, source := range r.sources {
+		log.Printf("Starting reader %s\n", source)
+		go r.readers[source].Run()
+	}
+}
+
+func (r *Registry) Status() []ReaderStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	statuses := make([]ReaderStatus, 0, len(r.sources))
+	for _, source := range r.sources {
+		status := ReaderStatus{Source: source}
+
+		if reporter, ok := r.readers[source].(FrameStatsReporter); ok {
+			stats := reporter.FrameStats()
+			status.FrameStats = &stats
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+func (r *Registry) HandleReaders(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.Status())
+}
@@ -0,0 +1,166 @@
+package replayreader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Controller exposes pause / resume / seek / speed for every replay reader over HTTP
+type Controller struct {
+	mu		sync.Mutex
+	readers	map[string]*ReplayReader
+}
+
+func NewController() *Controller {
+	return &Controller{
+		readers: make(map[string]*ReplayReader),
+	}
+}
+
+func (c *Controller) Add(reader *ReplayReader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readers[reader.Source] = reader
+}
+
+// lookup picks the reader named by ?source=, which can be left out when there is only one replay running
+func (c *Controller) lookup(req *http.Request) (*ReplayReader, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	source := req.URL.Query().Get("source")
+	if source == "" {
+		if len(c.readers) != 1 {
+			return nil, fmt.Errorf("%d replays are running, pick one with ?source=", len(c.readers))
+		}
+
+		for _, reader := range c.readers {
+			return reader, nil
+		}
+	}
+
+	reader, ok := c.readers[source]
+	if !ok {
+		return nil, fmt.Errorf("no replay with source %q", source)
+	}
+
+	return reader, nil
+}
+
+func (c *Controller) HandleStatus(w http.ResponseWriter, req *http.Request) {
+	c.mu.Lock()
+	statuses := make([]ReplayStatus, 0, len(c.readers))
+	for _, reader := range c.readers {
+		statuses = append(statuses, reader.Status())
+	}
+	c.mu.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Source < statuses[j].Source })
+
+	writeJSON(w, statuses)
+}
+
+func (c *Controller) HandlePause(w http.ResponseWriter, req *http.Request) {
+	reader, ok := c.controlRequest(w, req)
+	if !ok {
+		return
+	}
+
+	reader.Pause()
+	writeJSON(w, reader.Status())
+}
+
+func (c *Controller) HandleResume(w http.ResponseWriter, req *http.Request) {
+	reader, ok := c.controlRequest(w, req)
+	if !ok {
+		return
+	}
+
+	reader.Resume()
+	writeJSON(w, reader.Status())
+}
+
+// HandleSpeed takes ?x=2 for double speed, ?x=0 to replay as fast as possible
+func (c *Controller) HandleSpeed(w http.ResponseWriter, req *http.Request) {
+	reader, ok := c.controlRequest(w, req)
+	if !ok {
+		return
+	}
+
+	speed, err := strconv.ParseFloat(req.URL.Query().Get("x"), 64)
+	if err != nil {
+		http.Error(w, "x should be a number", http.StatusBadRequest)
+		return
+	}
+
+	if err := reader.SetSpeed(speed); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, reader.Status())
+}
+
+// HandleSeek takes either ?offset=90s relative to the start of the session or ?packet=1200
+func (c *Controller) HandleSeek(w http.ResponseWriter, req *http.Request) {
+	reader, ok := c.controlRequest(w, req)
+	if !ok {
+		return
+	}
+
+	query := req.URL.Query()
+	var err error
+
+	switch {
+	case query.Has("offset"):
+		offset, parseErr := time.ParseDuration(query.Get("offset"))
+		if parseErr != nil {
+			http.Error(w, "offset should be a duration such as 90s", http.StatusBadRequest)
+			return
+		}
+		err = reader.SeekToOffset(offset)
+	case query.Has("packet"):
+		position, parseErr := strconv.Atoi(query.Get("packet"))
+		if parseErr != nil {
+			http.Error(w, "packet should be an integer", http.StatusBadRequest)
+			return
+		}
+		err = reader.SeekToPacket(position)
+	default:
+		http.Error(w, "pass either offset or packet", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, reader.Status())
+}
+
+func (c *Controller) controlRequest(w http.ResponseWriter, req *http.Request) (*ReplayReader, bool) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "use POST to control a replay", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	reader, err := c.lookup(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+
+	return reader, true
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,441 @@
+package replayreader
+
+import (
+	"RP-UCLA/backend-reader/internal/recording"
+	tracereader "RP-UCLA/backend-reader/internal/traceReader"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// a speed of 0 pushes packets as fast as the processor can take them
+	AS_FAST_AS_POSSIBLE = 0
+
+	// replayed packets go out as replay:<session file name without the extension>
+	REPLAY_SOURCE_PREFIX = "replay:"
+)
+
+var errReplayFinished = errors.New("replay finished")
+
+type ReplayStatus struct {
+	Source			string		`json:"source"`
+	File			string		`json:"file"`
+	Speed			float64		`json:"speed"`
+	Paused			bool		`json:"paused"`
+	Finished		bool		`json:"finished"`
+	Position		int			`json:"position"`
+	TotalPackets	int			`json:"totalPackets"`
+	// offsets are relative to the first recorded packet, in microseconds
+	Offset			int64		`json:"offset"`
+	Duration		int64		`json:"duration"`
+}
+
+// ReplayReader feeds a recorded session back into the message queue as if it was coming off a board. packets are
+// streamed from the file rather than loaded up front, and go out as replay:<session> (replay:<session>/<device> when
+// the session holds more than one device) so a replay never shares device state with the live board it was recorded from
+type ReplayReader struct {
+	mu				sync.Mutex
+	MessageQueue	chan<- tracereader.RawPacket
+	Source			string
+	File			string
+
+	session			*recording.SessionReader
+	// the packet at position once it has been read off the session, nil until then
+	next			*tracereader.RawPacket
+	position		int
+	speed			float64
+	paused			bool
+	// set by a seek, every device is reset before the next packet goes out
+	resetPending	bool
+
+	// found by a scan of the file when the reader is created
+	totalPackets	int
+	firstAt			time.Time
+	lastAt			time.Time
+	// recorded device ID -> source its packets are replayed as
+	sources			map[string]string
+
+	// packet i is due at anchorWall + (packets[i].ReceivedAt - anchorRecorded) / speed
+	anchorWall		time.Time
+	anchorRecorded	time.Time
+
+	// poked whenever pause, seek or speed change so a pending wait can be re-evaluated
+	wake			chan struct{}
+}
+
+func NewReplayReader(source string, path string, speed float64, messageQueue chan<- tracereader.RawPacket) *ReplayReader {
+	if speed < 0 {
+		log.Fatalf("Replay speed cannot be negative, got %v\n", speed)
+	}
+
+	r := &ReplayReader{
+		MessageQueue: messageQueue,
+		Source: source,
+		File: path,
+		speed: speed,
+		wake: make(chan struct{}, 1),
+	}
+
+	if err := r.scanSession(); err != nil {
+		log.Fatalf("Unable to load session %s: %v\n", path, err)
+	}
+
+	if err := r.rewind(); err != nil {
+		log.Fatalf("Unable to open session %s: %v\n", path, err)
+	}
+
+	log.Printf("Replaying %d packets from %s\n", r.totalPackets, path)
+
+	return r
+}
+
+// scanSession reads through the whole file once for the packet count, time span and device IDs, without keeping any packets
+func (r *ReplayReader) scanSession() error {
+	session, err := recording.OpenSession(r.File)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	devices := make([]string, 0)
+	for {
+		packet, err := session.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// keep everything before a torn write at the end of the file
+			log.Printf("Stopping early while loading %s: %v\n", r.File, err)
+			break
+		}
+
+		if r.totalPackets == 0 {
+			r.firstAt = packet.ReceivedAt
+		}
+		r.lastAt = packet.ReceivedAt
+		r.totalPackets++
+
+		if !slices.Contains(devices, packet.Source) {
+			devices = append(devices, packet.Source)
+		}
+	}
+
+	sessionName := strings.TrimSuffix(filepath.Base(r.File), recording.FILE_EXTENSION)
+	r.sources = make(map[string]string, len(devices))
+	for _, device := range devices {
+		if len(devices) == 1 {
+			r.sources[device] = REPLAY_SOURCE_PREFIX + sessionName
+		} else {
+			r.sources[device] = REPLAY_SOURCE_PREFIX + sessionName + "/" + device
+		}
+	}
+
+	return nil
+}
+
+// rewind reopens the session so the next packet read is the first one, assumes the lock is held
+func (r *ReplayReader) rewind() error {
+	if r.session != nil {
+		r.session.Close()
+		r.session = nil
+	}
+
+	session, err := recording.OpenSession(r.File)
+	if err != nil {
+		return err
+	}
+
+	r.session = session
+	r.next = nil
+	r.position = 0
+
+	return nil
+}
+
+// peek reads the packet at position off the session if it hasn't been already, assumes the lock is held
+func (r *ReplayReader) peek() (tracereader.RawPacket, error) {
+	if r.next != nil {
+		return *r.next, nil
+	}
+
+	if r.position >= r.totalPackets {
+		return tracereader.RawPacket{}, errReplayFinished
+	}
+
+	packet, err := r.session.Next()
+	if err != nil {
+		// the file changed since it was scanned, the replay ends where it ends now
+		r.totalPackets = r.position
+		return packet, fmt.Errorf("unable to read packet %d of %s: %v", r.position, r.File, err)
+	}
+	r.next = &packet
+
+	return packet, nil
+}
+
+// skipTo moves the session to the packet at position, only going back to the start of the file when seeking backwards.
+// assumes the lock is held
+func (r *ReplayReader) skipTo(position int) error {
+	if position < r.position {
+		if err := r.rewind(); err != nil {
+			return err
+		}
+	}
+
+	for r.position < position {
+		if r.next == nil {
+			if _, err := r.session.Next(); err != nil {
+				return fmt.Errorf("unable to read packet %d of %s: %v", r.position, r.File, err)
+			}
+		}
+		r.next = nil
+		r.position++
+	}
+
+	return nil
+}
+
+func (r *ReplayReader) ReadPacket() error {
+	for {
+		r.mu.Lock()
+
+		if r.position >= r.totalPackets {
+			r.mu.Unlock()
+			return errReplayFinished
+		}
+
+		if r.paused {
+			r.mu.Unlock()
+			<-r.wake
+			continue
+		}
+
+		packet, err := r.peek()
+		if err != nil {
+			r.mu.Unlock()
+			return err
+		}
+
+		wait := r.waitFor(packet)
+		if wait <= 0 {
+			r.next = nil
+			r.position++
+			resetPending := r.resetPending
+			r.resetPending = false
+			r.mu.Unlock()
+
+			// the resets go through the queue so they land after everything sent before the seek
+			if resetPending {
+				for _, source := range r.replaySources() {
+					r.MessageQueue <- tracereader.RawPacket{Source: source, ReceivedAt: time.Now(), Reset: true}
+				}
+			}
+
+			packet.Source = r.sources[packet.Source]
+			r.MessageQueue <- packet
+			return nil
+		}
+		r.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-r.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// replaySources lists every source the session is replayed as, sorted so resets go out in the same order every time
+func (r *ReplayReader) replaySources() []string {
+	sources := make([]string, 0, len(r.sources))
+	for _, source := range r.sources {
+		sources = append(sources, source)
+	}
+	slices.Sort(sources)
+
+	return sources
+}
+
+func (r *ReplayReader) Run() {
+	announcedFinish := false
+
+	for {
+		err := r.ReadPacket()
+
+		if errors.Is(err, errReplayFinished) {
+			if !announcedFinish {
+				log.Printf("Replay of %s finished\n", r.File)
+				announcedFinish = true
+			}
+			// hang around in case somebody seeks back
+			<-r.wake
+			continue
+		}
+		announcedFinish = false
+
+		if err != nil {
+			fmt.Printf("%v\n", err)
+		}
+	}
+}
+
+// waitFor assumes the lock is held
+func (r *ReplayReader) waitFor(packet tracereader.RawPacket) time.Duration {
+	if r.speed == AS_FAST_AS_POSSIBLE {
+		return 0
+	}
+
+	if r.anchorWall.IsZero() {
+		r.reanchor()
+	}
+
+	recordedOffset := packet.ReceivedAt.Sub(r.anchorRecorded)
+	due := r.anchorWall.Add(time.Duration(float64(recordedOffset) / r.speed))
+
+	return time.Until(due)
+}
+
+// reanchor makes the packet at the current position due right now, assumes the lock is held
+func (r *ReplayReader) reanchor() {
+	r.anchorWall = time.Now()
+	if packet, err := r.peek(); err == nil {
+		r.anchorRecorded = packet.ReceivedAt
+	}
+}
+
+func (r *ReplayReader) poke() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *ReplayReader) Pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.paused = true
+	r.poke()
+}
+
+func (r *ReplayReader) Resume() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.paused = false
+	r.reanchor()
+	r.poke()
+}
+
+func (r *ReplayReader) SetSpeed(speed float64) error {
+	if speed < 0 {
+		return fmt.Errorf("replay speed cannot be negative, got %v", speed)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.speed = speed
+	r.reanchor()
+	r.poke()
+
+	return nil
+}
+
+// SeekToPacket moves playback to the given packet index. the packet after a seek doesn't follow on from the one before,
+// so the processor is told to drop its call stacks, time anchor and TraceId tracking for every device in the session
+func (r *ReplayReader) SeekToPacket(position int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if position < 0 || position > r.totalPackets {
+		return fmt.Errorf("packet %d is out of range, the session has %d packets", position, r.totalPackets)
+	}
+
+	if err := r.skipTo(position); err != nil {
+		return err
+	}
+	r.seeked()
+
+	return nil
+}
+
+// SeekToOffset moves playback to the first packet recorded at least offset after the start of the session
+func (r *ReplayReader) SeekToOffset(offset time.Duration) error {
+	if offset < 0 {
+		return fmt.Errorf("seek offset cannot be negative, got %v", offset)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.totalPackets == 0 {
+		return nil
+	}
+	target := r.firstAt.Add(offset)
+
+	// packets aren't indexed, so look for the target from the start of the file
+	if err := r.rewind(); err != nil {
+		return err
+	}
+	for {
+		packet, err := r.peek()
+		if errors.Is(err, errReplayFinished) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if !packet.ReceivedAt.Before(target) {
+			break
+		}
+		r.next = nil
+		r.position++
+	}
+	r.seeked()
+
+	return nil
+}
+
+// seeked assumes the lock is held
+func (r *ReplayReader) seeked() {
+	r.resetPending = true
+	r.reanchor()
+	r.poke()
+}
+
+func (r *ReplayReader) Status() ReplayStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := ReplayStatus{
+		Source: r.Source,
+		File: r.File,
+		Speed: r.speed,
+		Paused: r.paused,
+		Finished: r.position >= r.totalPackets,
+		Position: r.position,
+		TotalPackets: r.totalPackets,
+	}
+
+	if r.totalPackets > 0 {
+		status.Duration = r.lastAt.Sub(r.firstAt).Microseconds()
+
+		if packet, err := r.peek(); err == nil {
+			status.Offset = packet.ReceivedAt.Sub(r.firstAt).Microseconds()
+		} else {
+			status.Offset = status.Duration
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,123 @@
+package replayreader
+
+import (
+	"RP-UCLA/backend-reader/internal/recording"
+	tracereader "RP-UCLA/backend-reader/internal/traceReader"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordSession records one packet per device ID, a millisecond apart, with the packet index in the first byte
+func recordSession(t *testing.T, devices ...string) string {
+	dir := t.TempDir()
+	recorder := recording.NewRecorder(dir, 0, 0)
+	if err := recorder.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for idx, device := range devices {
+		packet := tracereader.RawPacket{Source: device, ReceivedAt: start.Add(time.Duration(idx) * time.Millisecond)}
+		packet.Data[0] = byte(idx)
+		if err := recorder.Record(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := recorder.Status().File
+	if err := recorder.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func sessionName(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), recording.FILE_EXTENSION)
+}
+
+func TestReplaySource(t *testing.T) {
+	path := recordSession(t, "board", "board")
+	queue := make(chan tracereader.RawPacket, 4)
+	replay := NewReplayReader("flight", path, AS_FAST_AS_POSSIBLE, queue)
+
+	for i := 0; i < 2; i++ {
+		if err := replay.ReadPacket(); err != nil {
+			t.Fatal(err)
+		}
+		packet := <-queue
+		if want := "replay:" + sessionName(path); packet.Source != want {
+			t.Errorf("packet %d replayed as %q, want %q", i, packet.Source, want)
+		}
+	}
+
+	if err := replay.ReadPacket(); err != errReplayFinished {
+		t.Errorf("ReadPacket after the last packet returned %v, want %v", err, errReplayFinished)
+	}
+}
+
+func TestReplaySourcePerDevice(t *testing.T) {
+	path := recordSession(t, "left", "right")
+	queue := make(chan tracereader.RawPacket, 4)
+	replay := NewReplayReader("flight", path, AS_FAST_AS_POSSIBLE, queue)
+
+	for _, device := range []string{"left", "right"} {
+		if err := replay.ReadPacket(); err != nil {
+			t.Fatal(err)
+		}
+		packet := <-queue
+		if want := "replay:" + sessionName(path) + "/" + device; packet.Source != want {
+			t.Errorf("packet from %s replayed as %q, want %q", device, packet.Source, want)
+		}
+	}
+}
+
+func TestReplaySeek(t *testing.T) {
+	path := recordSession(t, "board", "board", "board", "board")
+	queue := make(chan tracereader.RawPacket, 8)
+	replay := NewReplayReader("flight", path, AS_FAST_AS_POSSIBLE, queue)
+
+	// reads the next packet off the replay, skipping the resets a seek sends first
+	next := func() byte {
+		t.Helper()
+		if err := replay.ReadPacket(); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			packet := <-queue
+			if !packet.Reset {
+				return packet.Data[0]
+			}
+		}
+	}
+
+	if got := next(); got != 0 {
+		t.Fatalf("first packet is %d, want 0", got)
+	}
+
+	if err := replay.SeekToPacket(3); err != nil {
+		t.Fatal(err)
+	}
+	if got := next(); got != 3 {
+		t.Errorf("packet after seeking forward to 3 is %d", got)
+	}
+
+	if err := replay.SeekToPacket(1); err != nil {
+		t.Fatal(err)
+	}
+	if got := next(); got != 1 {
+		t.Errorf("packet after seeking back to 1 is %d", got)
+	}
+
+	if err := replay.SeekToOffset(2 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if status := replay.Status(); status.Position != 2 || status.Offset != 2000 || status.Duration != 3000 {
+		t.Errorf("status after seeking to 2ms is %+v, want position 2, offset 2000 and duration 3000", status)
+	}
+	if got := next(); got != 2 {
+		t.Errorf("packet after seeking to 2ms is %d", got)
+	}
+}
@@ -1,6 +1,22 @@
 package tracereader
 
+import "time"
+
+const (
+	RAW_PACKET_SIZE = 72
+)
+
 type TraceReader interface {
 	ReadPacket() error
 	Run()
-}
\ No newline at end of file
+}
+
+// RawPacket is a single undecoded trace packet, tagged with the reader it came from
+type RawPacket struct {
+	Source		string
+	ReceivedAt	time.Time
+	Data		[RAW_PACKET_SIZE]byte
+	// set on packets that carry no trace data and instead tell the processor to forget what it knows about Source,
+	// such as when a replay seeks and the next packet no longer follows on from the last one
+	Reset		bool
+}
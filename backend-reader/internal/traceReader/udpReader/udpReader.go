@@ -1,9 +1,11 @@
 package udpreader
 
 import (
+	tracereader "RP-UCLA/backend-reader/internal/traceReader"
 	"fmt"
 	"log"
 	"net"
+	"time"
 )
 
 const (
@@ -12,10 +14,11 @@ const (
 
 type UDPReader struct {
 	*net.UDPConn
-	MessageQueue 	chan<- [RAW_PACKET_SIZE]byte
+	MessageQueue 	chan<- tracereader.RawPacket
+	Source			string
 }
 
-func NewUDPReader(port string, messageQueue chan<- [RAW_PACKET_SIZE]byte) *UDPReader {
+func NewUDPReader(source string, port string, messageQueue chan<- tracereader.RawPacket) *UDPReader {
 	udpAddr, err := net.ResolveUDPAddr("udp", port)
 	if err != nil {
 		log.Fatalf("Unable to create UDP address %v", err)
@@ -31,6 +34,7 @@ func NewUDPReader(port string, messageQueue chan<- [RAW_PACKET_SIZE]byte) *UDPRe
 	return &UDPReader{
 		conn,
 	 	messageQueue,
+		source,
 	}
 }
 
@@ -49,7 +53,11 @@ func (u *UDPReader) ReadPacket() error {
 		count += n
 	}
 
-	u.MessageQueue <- buffer
+	u.MessageQueue <- tracereader.RawPacket{
+		Source: u.Source,
+		ReceivedAt: time.Now(),
+		Data: buffer,
+	}
 
 	return nil
 }
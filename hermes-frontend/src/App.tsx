@@ -2,6 +2,7 @@ import { useEffect, useRef, useState } from "react";
 import ExecutionLog from "./components/molecules/ExecutionLog";
 import {
     TraceTypes,
+    type StatEntry,
     type StatEntryWithoutName,
     type TraceEntryCallStack,
     type TrackedTraceEntry,
@@ -16,14 +17,24 @@ const webSocketUrl = "ws://localhost:8080/data";
 const MAX_EXECUTION_LOGS = 250;
 const MAX_FLAME_GRAPH_LOGS = 100_000;
 
+function toStatMap(statMap: StatEntry[]): Map<string, StatEntryWithoutName> {
+    const newStatMap = new Map<string, StatEntryWithoutName>();
+
+    statMap.forEach(({ funcName, ...stat }) => {
+        newStatMap.set(funcName, stat);
+    });
+    return newStatMap;
+}
+
 // NOTE: this should have a global state that it passes to all its children
 function App() {
     const webSocketRef = useRef<WebSocket | null>(null);
     const [connected, setConnected] = useState(false);
     const [executionLogs, setExecutionLogs] = useState<TrackedTraceEntry[]>([]);
-    const [stats, setStats] = useState<Map<string, StatEntryWithoutName>>(
-        new Map()
-    );
+    // every message only carries the stats of one device, so they are kept per deviceId
+    const [stats, setStats] = useState<
+        Map<string, Map<string, StatEntryWithoutName>>
+    >(new Map());
     const [flameGraphLogs, setFlameGraphLogs] = useState<TraceEntryCallStack[]>(
         []
     );
@@ -50,24 +61,30 @@ function App() {
             webSocketRef.current.onmessage = (e) => {
                 const parsed: TrackedTraceEntry = JSON.parse(e.data);
 
-                if (parsed.traceType === TraceTypes.STAT_UPDATES) {
-                    const newStatMap = new Map();
-
-                    parsed.statMap.forEach(
-                        ({
-                            funcName,
-                            callsMade,
-                            averageRunTime,
-                            maxRunTime,
-                        }) => {
-                            newStatMap.set(funcName, {
-                                callsMade,
-                                averageRunTime,
-                                maxRunTime,
-                            });
-                        }
+                if (parsed.traceType === TraceTypes.SNAPSHOT) {
+                    setStats((prevStats) =>
+                        new Map(prevStats).set(
+                            parsed.deviceId,
+                            toStatMap(parsed.stats.statMap)
+                        )
+                    );
+                    setFlameGraphLogs((logs) => [...logs, ...parsed.recentCalls]);
+
+                    const missed = [parsed.lastPanic, parsed.lastRestart]
+                        .filter((entry) => entry !== null)
+                        .sort((a, b) => Number(b.timestamp) - Number(a.timestamp));
+                    setExecutionLogs((prevExecutionLogs) => [
+                        ...prevExecutionLogs,
+                        ...missed,
+                    ]);
+                } else if (parsed.traceType === TraceTypes.STAT_UPDATES) {
+                    // an update holds every function of the device's current boot, so it replaces only that device
+                    setStats((prevStats) =>
+                        new Map(prevStats).set(
+                            parsed.deviceId,
+                            toStatMap(parsed.statMap)
+                        )
                     );
-                    setStats(newStatMap);
                 } else if (parsed.traceType === TraceTypes.FLAME_GRAPH_ENTRY) {
                     setFlameGraphLogs((logs) => {
                         const newLogs = [...logs, parsed];
@@ -176,7 +193,21 @@ function App() {
                             />
                         )}
                         <div className="grid grid-cols-2 gap-4">
-                            <StatTable statMap={stats} />
+                            <div className="flex flex-col gap-4">
+                                {stats.size === 0 ? (
+                                    <StatTable statMap={new Map()} />
+                                ) : (
+                                    Array.from(stats.entries())
+                                        .sort(([a], [b]) => a.localeCompare(b))
+                                        .map(([deviceId, statMap]) => (
+                                            <StatTable
+                                                key={deviceId}
+                                                deviceId={deviceId}
+                                                statMap={statMap}
+                                            />
+                                        ))
+                                )}
+                            </div>
                             <ExecutionLog executionLog={executionLogs} />
                         </div>
                     </div>
@@ -5,6 +5,7 @@ import { Activity, BarChart3, Clock3, Flame } from "lucide-react";
 
 interface StatTableProps {
     statMap: Map<string, StatEntryWithoutName>;
+    deviceId?: string;
 }
 
 function formatDurationNs(micros: number): string {
@@ -24,7 +25,7 @@ function formatDurationNs(micros: number): string {
     return `${micro.toFixed(2)} µs`;
 }
 
-export default function StatTable({ statMap }: StatTableProps) {
+export default function StatTable({ statMap, deviceId }: StatTableProps) {
     const entries = Array.from(statMap.entries());
 
     // Sort by hottest functions (max runtime) descending, then by calls made
@@ -49,7 +50,9 @@ export default function StatTable({ statMap }: StatTableProps) {
                             Function execution stats
                         </h2>
                         <p className="text-xs text-muted-foreground">
-                            Live aggregate timings by function.
+                            {deviceId
+                                ? `Live aggregate timings by function on ${deviceId}.`
+                                : "Live aggregate timings by function."}
                         </p>
                     </div>
                 </div>
@@ -82,9 +85,21 @@ export default function StatTable({ statMap }: StatTableProps) {
                                     <th className="px-3 py-2 text-right font-medium">
                                         Avg runtime
                                     </th>
+                                    <th className="px-3 py-2 text-right font-medium">
+                                        Min runtime
+                                    </th>
                                     <th className="px-3 py-2 text-right font-medium">
                                         Max runtime
                                     </th>
+                                    <th className="px-3 py-2 text-right font-medium">
+                                        Avg self
+                                    </th>
+                                    <th className="px-3 py-2 text-right font-medium">
+                                        Total self
+                                    </th>
+                                    <th className="px-3 py-2 text-right font-medium">
+                                        Total time
+                                    </th>
                                 </tr>
                             </thead>
                             <tbody>
@@ -93,6 +108,10 @@ export default function StatTable({ statMap }: StatTableProps) {
                                         callsMade,
                                         averageRunTime,
                                         maxRunTime,
+                                        minRunTime,
+                                        averageSelfTime,
+                                        totalSelfTime,
+                                        totalRunTime,
                                     } = stat;
 
                                     const isHot =
@@ -125,9 +144,23 @@ export default function StatTable({ statMap }: StatTableProps) {
                                                     averageRunTime
                                                 )}
                                             </td>
+                                            <td className="whitespace-nowrap px-3 py-2 text-right align-middle text-slate-200">
+                                                {formatDurationNs(minRunTime)}
+                                            </td>
                                             <td className="whitespace-nowrap px-3 py-2 text-right align-middle text-slate-200">
                                                 {formatDurationNs(maxRunTime)}
                                             </td>
+                                            <td className="whitespace-nowrap px-3 py-2 text-right align-middle text-slate-200">
+                                                {formatDurationNs(
+                                                    averageSelfTime
+                                                )}
+                                            </td>
+                                            <td className="whitespace-nowrap px-3 py-2 text-right align-middle text-slate-200">
+                                                {formatDurationNs(totalSelfTime)}
+                                            </td>
+                                            <td className="whitespace-nowrap px-3 py-2 text-right align-middle text-slate-200">
+                                                {formatDurationNs(totalRunTime)}
+                                            </td>
                                         </tr>
                                     );
                                 })}
@@ -5,6 +5,14 @@ export enum TraceTypes {
     RESTART = 3,
     FLAME_GRAPH_ENTRY = 4,
     STAT_UPDATES = 5,
+    PACKET_LOSS = 6,
+    DIAGNOSTIC = 7,
+    TASK_SWITCH_IN = 8,
+    TASK_SWITCH_OUT = 9,
+    TASK_TIMELINE = 10,
+    DEADLINE_MISS = 11,
+    ALERT = 12,
+    SNAPSHOT = 13,
 }
 
 export type TraceEntryEnter = {
@@ -13,6 +21,7 @@ export type TraceEntryEnter = {
     timestamp: string;
     traceId: number;
     funcCallId: number;
+    deviceId: string;
     argCount: number;
     funcArgs: number[];
     funcName: string;
@@ -25,6 +34,7 @@ export type TraceEntryExit = {
     timestamp: string;
     traceId: number;
     funcCallId: number;
+    deviceId: string;
     returnVal: number;
     funcName: string;
     packetId: string;
@@ -36,23 +46,47 @@ export type TraceEntryPanic = {
     timestamp: string;
     traceId: number;
     funcCallId: number;
+    deviceId: string;
     faultingPC: number;
     exceptionReason: string;
+    faultFunction?: string;
+    faultFile?: string;
+    faultLine?: number;
     packetId: string;
 };
 
 export type TraceEntryRestart = {
     traceType: TraceTypes.RESTART;
+    deviceId: string;
     coreId: number;
     timestamp: string;
     restartReason: string;
     packetId: string;
 };
 
+export type WindowStatEntry = {
+    callsMade: number;
+    averageRunTime: number;
+    minRunTime: number;
+    maxRunTime: number;
+    averageSelfTime: number;
+};
+
 export type StatEntry = {
     callsMade: number;
     averageRunTime: number;
     maxRunTime: number;
+    minRunTime: number;
+    totalRunTime: number;
+    averageSelfTime: number;
+    maxSelfTime: number;
+    totalSelfTime: number;
+    p50: number;
+    p90: number;
+    p99: number;
+    p999: number;
+    stdDev: number;
+    windows: Record<"1s" | "10s" | "60s", WindowStatEntry>;
     funcName: string;
 };
 
@@ -60,6 +94,8 @@ export type StatEntryWithoutName = Omit<StatEntry, "funcName">;
 
 export type TraceEntryStat = {
     traceType: TraceTypes.STAT_UPDATES;
+    deviceId: string;
+    boot: number;
     statMap: StatEntry[];
 };
 
@@ -70,7 +106,10 @@ export type TraceEntryCallStack = {
     timestamp: string;
     traceId: number;
     depth: number;
+    taskId: number;
+    taskName?: string;
     funcCallId: number;
+    deviceId: string;
     argCount: number;
     funcArgs: number[];
     funcName: string;
@@ -80,6 +119,89 @@ export type TraceEntryCallStack = {
     endTime: string;
     parentFunctionId: number;
     childFunctionIds: number[];
+    incomplete: boolean;
+    truncated: boolean;
+    anomalyScore: number;
+    anomalous: boolean;
+};
+
+export type TraceEntryPacketLoss = {
+    traceType: TraceTypes.PACKET_LOSS;
+    deviceId: string;
+    coreId: number;
+    timestamp: string;
+    lastTraceId: number;
+    receivedTraceId: number;
+    packetsLost: number;
+    totalPacketsLost: number;
+    affectedFuncCallIds: number[];
+    packetId: string;
+};
+
+export type TraceEntryDiagnostic = {
+    traceType: TraceTypes.DIAGNOSTIC;
+    deviceId: string;
+    coreId: number;
+    timestamp: string;
+    kind: "stack_unwound" | "orphan_exit" | "stale_frame" | "root_restarted" | "trace_id_reset" | "unknown_core";
+    message: string;
+    funcCallId: number;
+    affectedFuncCallIds: number[];
+    packetId: string;
+};
+
+export type TraceEntryTaskTimeline = {
+    traceType: TraceTypes.TASK_TIMELINE;
+    deviceId: string;
+    coreId: number;
+    taskId: number;
+    taskName: string;
+    priority: number;
+    startTime: string;
+    endTime: string;
+    packetId: string;
+};
+
+export type TraceEntryDeadlineMiss = {
+    traceType: TraceTypes.DEADLINE_MISS;
+    deviceId: string;
+    coreId: number;
+    taskId: number;
+    funcName: string;
+    funcCallId: number;
+    kind: "overrun" | "jitter";
+    startTime: string;
+    duration: number;
+    deadline: number;
+    period: number;
+    expectedPeriod: number;
+    jitter: number;
+    missedCycles: number;
+    packetId: string;
+};
+
+export type TraceEntryAlert = {
+    traceType: TraceTypes.ALERT;
+    rule: string;
+    when: string;
+    message: string;
+    deviceId: string;
+    coreId: number;
+    funcName?: string;
+    funcCallId?: number;
+    timestamp: string;
+    packetId: string;
+};
+
+// sent once per device when connecting, catches the client up on what it missed
+export type TraceEntrySnapshot = {
+    traceType: TraceTypes.SNAPSHOT;
+    deviceId: string;
+    openCalls: { coreId: number; taskId: number; calls: TraceEntryCallStack[] }[];
+    stats: TraceEntryStat;
+    lastRestart: TraceEntryRestart | null;
+    lastPanic: TraceEntryPanic | null;
+    recentCalls: TraceEntryCallStack[];
 };
 
 export type TraceEntry =
@@ -88,6 +210,28 @@ export type TraceEntry =
     | TraceEntryPanic
     | TraceEntryRestart
     | TraceEntryCallStack
-    | TraceEntryStat;
+    | TraceEntryStat
+    | TraceEntryPacketLoss
+    | TraceEntryDiagnostic
+    | TraceEntryTaskTimeline
+    | TraceEntryDeadlineMiss
+    | TraceEntryAlert
+    | TraceEntrySnapshot;
 
 export type TrackedTraceEntry = TraceEntry;
+
+// websocket subprotocols, batched ones receive an array of messages per frame and msgpack ones binary frames
+export type Subprotocol = "hermes.json" | "hermes.json.batch" | "hermes.msgpack" | "hermes.msgpack.batch";
+
+// sent over /data to narrow down what the backend streams, empty lists let everything through
+export type SubscribeMessage = {
+    type: "subscribe";
+    traceTypes?: TraceTypes[];
+    cores?: number[];
+    devices?: string[];
+    funcPatterns?: string[];
+};
+
+export type ClientReply =
+    | { type: "subscribed"; subscription: Omit<SubscribeMessage, "type"> }
+    | { type: "error"; message: string };